@@ -2,10 +2,12 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/NodePassProject/cert"
@@ -15,11 +17,15 @@ import (
 
 // start 启动核心逻辑
 func start(args []string) error {
+	// 显式指定-c时按多隧道配置文件启动
+	if len(args) == 3 && args[1] == "-c" {
+		return startTunnels(args[2])
+	}
 	if len(args) != 2 {
 		return fmt.Errorf("start: empty URL command")
 	}
 
-	parsedURL, err := url.Parse(args[1])
+	parsedURL, err := internal.ParseURL(args[1])
 	if err != nil {
 		return fmt.Errorf("start: parse URL failed: %w", err)
 	}
@@ -35,6 +41,67 @@ func start(args []string) error {
 	return nil
 }
 
+// tunnelsConfig 多隧道配置文件
+type tunnelsConfig struct {
+	Log     string   `json:"log"`     // 日志级别
+	Tunnels []string `json:"tunnels"` // 隧道URL列表
+}
+
+// startTunnels 在单进程内运行配置文件中的多个隧道
+func startTunnels(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("startTunnels: read config failed: %w", err)
+	}
+
+	var config tunnelsConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("startTunnels: parse config failed: %w", err)
+	}
+	if len(config.Tunnels) == 0 {
+		return fmt.Errorf("startTunnels: no tunnels in %v", filePath)
+	}
+
+	logger := initLogger(config.Log)
+
+	// 创建全部核心，任一失败则不启动
+	cores := make([]interface{ Run() }, 0, len(config.Tunnels))
+	for i, rawURL := range config.Tunnels {
+		parsedURL, err := internal.ParseURL(rawURL)
+		if err != nil {
+			return fmt.Errorf("startTunnels: parse tunnel %v failed: %w", i, err)
+		}
+		if parsedURL.Scheme != "server" && parsedURL.Scheme != "client" {
+			return fmt.Errorf("startTunnels: unsupported tunnel %v: %v", i, parsedURL.Scheme)
+		}
+		if parsedURL.Query().Has("log") {
+			logger.Warn("Tunnel %v log parameter ignored, using config log level", i)
+		}
+		core, err := createCore(parsedURL, internal.NewPrefixLogger(logger, tunnelPrefix(i, parsedURL)))
+		if err != nil {
+			return fmt.Errorf("startTunnels: create tunnel %v failed: %w", i, err)
+		}
+		cores = append(cores, core)
+	}
+
+	// 各隧道独立运行并处理重启，收到退出信号后全部关闭
+	logger.Info("Tunnels started: %v from %v", len(cores), filePath)
+	var wg sync.WaitGroup
+	for _, core := range cores {
+		wg.Go(core.Run)
+	}
+	wg.Wait()
+	return nil
+}
+
+// tunnelPrefix 返回隧道日志前缀，优先使用URL片段作为别名
+func tunnelPrefix(index int, parsedURL *url.URL) string {
+	if parsedURL.Fragment != "" {
+		return fmt.Sprintf("[%v]", parsedURL.Fragment)
+	}
+	return fmt.Sprintf("[%v]", index)
+}
+
 // initLogger 初始化日志记录器
 func initLogger(level string) *logs.Logger {
 	logger := logs.NewLogger(logs.Info, true)
@@ -59,15 +126,21 @@ func initLogger(level string) *logs.Logger {
 }
 
 // createCore 创建核心
-func createCore(parsedURL *url.URL, logger *logs.Logger) (interface{ Run() }, error) {
+func createCore(parsedURL *url.URL, logger internal.Logger) (interface{ Run() }, error) {
 	switch parsedURL.Scheme {
 	case "server":
-		tlsCode, tlsConfig := getTLSProtocol(parsedURL, logger)
+		tlsCode, tlsConfig, err := getTLSProtocol(parsedURL, logger)
+		if err != nil {
+			return nil, fmt.Errorf("createCore: %w", err)
+		}
 		return internal.NewServer(parsedURL, tlsCode, tlsConfig, logger)
 	case "client":
 		return internal.NewClient(parsedURL, logger)
 	case "master":
-		tlsCode, tlsConfig := getTLSProtocol(parsedURL, logger)
+		tlsCode, tlsConfig, err := getTLSProtocol(parsedURL, logger)
+		if err != nil {
+			return nil, fmt.Errorf("createCore: %w", err)
+		}
 		return internal.NewMaster(parsedURL, tlsCode, tlsConfig, logger, version)
 	default:
 		return nil, fmt.Errorf("createCore: unknown core: %v", parsedURL)
@@ -75,13 +148,13 @@ func createCore(parsedURL *url.URL, logger *logs.Logger) (interface{ Run() }, er
 }
 
 // getTLSProtocol 获取TLS配置
-func getTLSProtocol(parsedURL *url.URL, logger *logs.Logger) (string, *tls.Config) {
+func getTLSProtocol(parsedURL *url.URL, logger internal.Logger) (string, *tls.Config, error) {
 	// 生成基本TLS配置
 	tlsConfig, err := cert.NewTLSConfig(version)
 	if err != nil {
 		logger.Error("Generate TLS config failed: %v", err)
 		logger.Warn("TLS code-0: nil cert")
-		return "0", nil
+		return "0", nil, nil
 	}
 
 	tlsConfig.MinVersion = tls.VersionTLS13
@@ -90,36 +163,14 @@ func getTLSProtocol(parsedURL *url.URL, logger *logs.Logger) (string, *tls.Confi
 	case "1":
 		// 使用内存自签证书
 		logger.Info("TLS code-1: RAM cert with TLS 1.3")
-		return "1", tlsConfig
+		return "1", tlsConfig, nil
 	case "2":
 		// 使用自定义证书
-		crtFile, keyFile := parsedURL.Query().Get("crt"), parsedURL.Query().Get("key")
-		cert, err := tls.LoadX509KeyPair(crtFile, keyFile)
+		certConfig, cert, err := loadCertConfig(parsedURL, logger)
 		if err != nil {
 			logger.Error("Certificate load failed: %v", err)
 			logger.Warn("TLS code-1: RAM cert with TLS 1.3")
-			return "1", tlsConfig
-		}
-
-		// 缓存证书并设置自动重载
-		cachedCert := cert
-		lastReload := time.Now()
-		tlsConfig = &tls.Config{
-			MinVersion: tls.VersionTLS13,
-			GetCertificate: func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-				// 定期重载证书
-				if time.Since(lastReload) >= internal.ReloadInterval {
-					newCert, err := tls.LoadX509KeyPair(crtFile, keyFile)
-					if err != nil {
-						logger.Error("Certificate reload failed: %v", err)
-					} else {
-						logger.Debug("TLS cert reloaded: %v", crtFile)
-						cachedCert = newCert
-					}
-					lastReload = time.Now()
-				}
-				return &cachedCert, nil
-			},
+			return "1", tlsConfig, nil
 		}
 
 		if cert.Leaf != nil {
@@ -127,17 +178,81 @@ func getTLSProtocol(parsedURL *url.URL, logger *logs.Logger) (string, *tls.Confi
 		} else {
 			logger.Warn("TLS code-2: unknown cert name with TLS 1.3")
 		}
-		return "2", tlsConfig
+		return "2", certConfig, nil
+	case "acme":
+		// 使用ACME自动签发证书
+		acmeConfig, err := internal.NewACMEConfig(parsedURL, logger)
+		if err != nil {
+			logger.Error("ACME setup failed: %v", err)
+			logger.Warn("TLS code-1: RAM cert with TLS 1.3")
+			return "1", tlsConfig, nil
+		}
+
+		logger.Info("TLS code-2: ACME cert for %v with TLS 1.3", acmeConfig.ServerName)
+		return "2", acmeConfig, nil
+	case "3":
+		// 双向TLS失败时不降级
+		if parsedURL.Scheme != "server" {
+			return "", nil, fmt.Errorf("getTLSProtocol: tls=3 is only supported in server mode")
+		}
+		if poolType := parsedURL.Query().Get("type"); poolType != "" && poolType != "0" {
+			return "", nil, fmt.Errorf("getTLSProtocol: tls=3 requires type=0")
+		}
+		certConfig, cert, err := loadCertConfig(parsedURL, logger)
+		if err != nil {
+			return "", nil, fmt.Errorf("getTLSProtocol: %w", err)
+		}
+		if err := internal.RequireClientCert(certConfig, parsedURL.Query().Get("ca"), parsedURL.Query().Get("sub")); err != nil {
+			return "", nil, fmt.Errorf("getTLSProtocol: %w", err)
+		}
+
+		if cert.Leaf != nil {
+			logger.Info("TLS code-3: %v with mutual TLS 1.3", cert.Leaf.Subject.CommonName)
+		} else {
+			logger.Warn("TLS code-3: unknown cert name with mutual TLS 1.3")
+		}
+		return "3", certConfig, nil
 	default:
 		if poolType := parsedURL.Query().Get("type"); poolType == "1" || poolType == "3" {
 			// 流池类型不支持明文传输
 			logger.Info("TLS code-1: RAM cert with TLS 1.3 for stream pool")
-			return "1", tlsConfig
+			return "1", tlsConfig, nil
 		}
 		// 不使用加密
 		logger.Warn("TLS code-0: unencrypted")
-		return "0", nil
+		return "0", nil, nil
+	}
+}
+
+// loadCertConfig 加载自定义证书并设置自动重载
+func loadCertConfig(parsedURL *url.URL, logger internal.Logger) (*tls.Config, *tls.Certificate, error) {
+	crtFile, keyFile := parsedURL.Query().Get("crt"), parsedURL.Query().Get("key")
+	cert, err := tls.LoadX509KeyPair(crtFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 缓存证书并设置自动重载
+	cachedCert := cert
+	lastReload := time.Now()
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		GetCertificate: func(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// 定期重载证书
+			if time.Since(lastReload) >= internal.ReloadInterval {
+				newCert, err := tls.LoadX509KeyPair(crtFile, keyFile)
+				if err != nil {
+					logger.Error("Certificate reload failed: %v", err)
+				} else {
+					logger.Debug("TLS cert reloaded: %v", crtFile)
+					cachedCert = newCert
+				}
+				lastReload = time.Now()
+			}
+			return &cachedCert, nil
+		},
 	}
+	return tlsConfig, &cert, nil
 }
 
 // exit 退出程序并显示帮助信息
@@ -153,6 +268,7 @@ func exit(err error) {
 │ server://password@host/host?<query> │
 │ client://password@host/host?<query> │
 │ master://hostname:port/path?<query> │
+│ -c /path/to/tunnels.json            │
 ╰─────────────────────────────────────╯
 
 `, 36, fmt.Sprintf("nodepass-%s", version), 36, fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH))
@@ -10,6 +10,7 @@ require (
 	github.com/NodePassProject/npws v1.0.6
 	github.com/NodePassProject/pool v1.0.50
 	github.com/NodePassProject/quic v1.0.14
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,358 @@
+// 内部包，实现来源访问控制
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// accessControl 来源访问控制配置
+type accessControl struct {
+	allow  []netip.Prefix // 允许来源
+	deny   []netip.Prefix // 拒绝来源
+	ipSlot int32          // 单来源并发连接上限
+	ipRate int32          // 单来源每秒新建连接上限
+}
+
+// shapingConfig 单连接与单来源带宽整形配置，单位为字节每秒
+type shapingConfig struct {
+	connUp   int64 // 单连接上行
+	connDown int64 // 单连接下行
+	ipUp     int64 // 单来源上行
+	ipDown   int64 // 单来源下行
+}
+
+// sourceState 单个来源的连接状态
+type sourceState struct {
+	active  int32             // 活跃连接数
+	window  int64             // 速率窗口起点
+	count   int32             // 窗口内新建连接数
+	shaped  int32             // 整形连接数
+	limiter *bandwidthLimiter // 来源限速器
+}
+
+// sourceTable 来源连接状态表
+type sourceTable struct {
+	mu        sync.Mutex                  // 状态表互斥锁
+	states    map[netip.Addr]*sourceState // 来源状态
+	lastSweep time.Time                   // 上次清理时间
+}
+
+// parsePrefixes 解析逗号分隔的CIDR或IP列表
+func (c *Common) parsePrefixes(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for entry := range strings.SplitSeq(c.parsedURL.Query().Get(key), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				c.logger.Warn("parsePrefixes: invalid %v entry ignored: %v", key, entry)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// containsAddr 判断地址是否位于任一网段
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAccessControl 获取来源访问控制设置
+func (c *Common) getAccessControl() {
+	access := &accessControl{
+		allow: c.parsePrefixes("allow"),
+		deny:  c.parsePrefixes("deny"),
+	}
+	if ipSlot := c.parsedURL.Query().Get("ipslot"); ipSlot != "" {
+		if value, err := strconv.Atoi(ipSlot); err == nil && value > 0 {
+			access.ipSlot = int32(value)
+		}
+	}
+	if ipRate := c.parsedURL.Query().Get("ipcps"); ipRate != "" {
+		if value, err := strconv.Atoi(ipRate); err == nil && value > 0 {
+			access.ipRate = int32(value)
+		}
+	}
+
+	// 未配置任何规则时跳过检查
+	if len(access.allow) == 0 && len(access.deny) == 0 && access.ipSlot == 0 && access.ipRate == 0 {
+		access = nil
+	}
+	c.access.Store(access)
+}
+
+// admitSource 按访问控制规则准入来源，返回释放函数
+func (c *Common) admitSource(addr net.Addr) (func(), error) {
+	access := c.access.Load()
+	if access == nil {
+		return func() {}, nil
+	}
+
+	addrPort, err := proxyAddrPort(addr)
+	if err != nil {
+		atomic.AddUint64(&c.rejects, 1)
+		return nil, fmt.Errorf("admitSource: invalid source %v", addr)
+	}
+	ip := addrPort.Addr().Unmap()
+
+	// 拒绝列表优先，允许列表非空时仅放行匹配来源
+	if containsAddr(access.deny, ip) {
+		atomic.AddUint64(&c.rejects, 1)
+		return nil, fmt.Errorf("admitSource: %v denied", ip)
+	}
+	if len(access.allow) > 0 && !containsAddr(access.allow, ip) {
+		atomic.AddUint64(&c.rejects, 1)
+		return nil, fmt.Errorf("admitSource: %v not allowed", ip)
+	}
+	if access.ipSlot == 0 && access.ipRate == 0 {
+		return func() {}, nil
+	}
+
+	c.sources.mu.Lock()
+	defer c.sources.mu.Unlock()
+
+	now := time.Now()
+	c.sources.sweep(now)
+	state := c.sources.states[ip]
+	if state == nil {
+		state = &sourceState{}
+		c.sources.states[ip] = state
+	}
+
+	// 单来源并发连接限制
+	if access.ipSlot > 0 && state.active >= access.ipSlot {
+		atomic.AddUint64(&c.rejects, 1)
+		return nil, fmt.Errorf("admitSource: %v connection limit reached: %v/%v", ip, state.active, access.ipSlot)
+	}
+
+	// 单来源新建连接速率限制
+	if access.ipRate > 0 {
+		if now.UnixNano()-state.window >= int64(time.Second) {
+			state.window, state.count = now.UnixNano(), 0
+		}
+		if state.count >= access.ipRate {
+			atomic.AddUint64(&c.rejects, 1)
+			return nil, fmt.Errorf("admitSource: %v connection rate exceeded: %v/s", ip, access.ipRate)
+		}
+		state.count++
+	}
+
+	state.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.sources.mu.Lock()
+			state.active--
+			c.sources.mu.Unlock()
+		})
+	}, nil
+}
+
+// sweep 清理空闲来源状态
+func (t *sourceTable) sweep(now time.Time) {
+	if t.states == nil {
+		t.states = make(map[netip.Addr]*sourceState)
+	}
+	if now.Sub(t.lastSweep) < reportInterval {
+		return
+	}
+	t.lastSweep = now
+	for ip, state := range t.states {
+		if state.active <= 0 && state.shaped <= 0 && now.UnixNano()-state.window >= int64(time.Second) {
+			delete(t.states, ip)
+		}
+	}
+}
+
+// parseBandwidth 解析UP[:DOWN]格式的带宽，单位为Mbps
+func parseBandwidth(value string) (int64, int64) {
+	if value == "" {
+		return 0, 0
+	}
+	upValue, downValue, found := strings.Cut(value, ":")
+	if !found {
+		downValue = upValue
+	}
+	up, err := strconv.ParseInt(upValue, 10, 64)
+	if err != nil || up < 0 {
+		up = 0
+	}
+	down, err := strconv.ParseInt(downValue, 10, 64)
+	if err != nil || down < 0 {
+		down = 0
+	}
+	return up * 125000, down * 125000
+}
+
+// getBandwidthShaping 获取单连接与单来源带宽整形设置
+func (c *Common) getBandwidthShaping() {
+	shaping := &shapingConfig{}
+	shaping.connUp, shaping.connDown = parseBandwidth(c.parsedURL.Query().Get("connrate"))
+	shaping.ipUp, shaping.ipDown = parseBandwidth(c.parsedURL.Query().Get("iprate"))
+	if *shaping == (shapingConfig{}) {
+		shaping = nil
+	}
+	c.shaping.Store(shaping)
+
+	// 同步调整现有来源限速器
+	c.sources.mu.Lock()
+	for _, state := range c.sources.states {
+		if state.limiter == nil {
+			continue
+		}
+		if shaping != nil {
+			state.limiter.setRate(shaping.ipUp, shaping.ipDown)
+		} else {
+			state.limiter.setRate(0, 0)
+		}
+	}
+	c.sources.mu.Unlock()
+}
+
+// shapeConn 在全局限速之下叠加单连接与单来源限速器，返回包装连接与释放函数
+func (c *Common) shapeConn(target net.Conn) (net.Conn, func()) {
+	shaping := c.shaping.Load()
+	if shaping == nil {
+		return target, func() {}
+	}
+
+	var limiters []*bandwidthLimiter
+	if shaping.connUp > 0 || shaping.connDown > 0 {
+		limiters = append(limiters, newBandwidthLimiter(shaping.connUp, shaping.connDown))
+	}
+
+	// 同一来源的连接共享限速器
+	release := func() {}
+	if shaping.ipUp > 0 || shaping.ipDown > 0 {
+		if addrPort, err := proxyAddrPort(target.RemoteAddr()); err == nil {
+			ip := addrPort.Addr().Unmap()
+			c.sources.mu.Lock()
+			c.sources.sweep(time.Now())
+			state := c.sources.states[ip]
+			if state == nil {
+				state = &sourceState{}
+				c.sources.states[ip] = state
+			}
+			if state.limiter == nil {
+				state.limiter = newBandwidthLimiter(shaping.ipUp, shaping.ipDown)
+			}
+			state.shaped++
+			c.sources.mu.Unlock()
+
+			limiters = append(limiters, state.limiter)
+			var once sync.Once
+			release = func() {
+				once.Do(func() {
+					c.sources.mu.Lock()
+					state.shaped--
+					c.sources.mu.Unlock()
+				})
+			}
+		}
+	}
+
+	if len(limiters) == 0 {
+		return target, release
+	}
+	return &shapedConn{Conn: target, limiters: limiters}, release
+}
+
+// shapedConn 叠加多级限速器的连接，读取为上行，写入为下行
+type shapedConn struct {
+	net.Conn
+	limiters []*bandwidthLimiter // 限速器组
+}
+
+// Read 读取后等待上行令牌
+func (sc *shapedConn) Read(b []byte) (int, error) {
+	n, err := sc.Conn.Read(b)
+	for _, limiter := range sc.limiters {
+		limiter.up.wait(int64(n))
+	}
+	return n, err
+}
+
+// Write 写入前等待下行令牌
+func (sc *shapedConn) Write(b []byte) (int, error) {
+	for _, limiter := range sc.limiters {
+		limiter.down.wait(int64(len(b)))
+	}
+	return sc.Conn.Write(b)
+}
+
+// bandwidthLimiter 上下行独立的限速器
+type bandwidthLimiter struct {
+	up   tokenBucket // 上行令牌桶
+	down tokenBucket // 下行令牌桶
+}
+
+// newBandwidthLimiter 创建限速器，速率为0表示不限制
+func newBandwidthLimiter(up, down int64) *bandwidthLimiter {
+	limiter := &bandwidthLimiter{}
+	limiter.setRate(up, down)
+	return limiter
+}
+
+// setRate 动态调整上下行速率
+func (l *bandwidthLimiter) setRate(up, down int64) {
+	l.up.setRate(up)
+	l.down.setRate(down)
+}
+
+// tokenBucket 允许透支的令牌桶，按欠额休眠，单次请求可超过桶容量
+type tokenBucket struct {
+	mu     sync.Mutex // 令牌桶互斥锁
+	rate   float64    // 每秒字节数
+	tokens float64    // 当前令牌数
+	last   time.Time  // 上次更新时间
+}
+
+// setRate 设置速率并重新填满令牌桶
+func (b *tokenBucket) setRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = float64(max(rate, 0))
+	b.tokens = b.rate
+	b.last = time.Now()
+}
+
+// wait 消耗令牌，不足时休眠至欠额补齐
+func (b *tokenBucket) wait(n int64) {
+	if n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	delay := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
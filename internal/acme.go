@@ -0,0 +1,685 @@
+// 内部包，实现ACME证书自动签发与续期
+package internal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ACME常量
+const (
+	acmeALPNProto     = "acme-tls/1"                   // TLS-ALPN-01协议标识
+	acmeChallengePath = "/.well-known/acme-challenge/" // HTTP-01验证路径
+	acmePollInterval  = 2 * time.Second                // 状态轮询间隔
+	acmePollTimeout   = 3 * time.Minute                // 状态轮询超时
+	acmeRetryMin      = 1 * time.Minute                // 签发失败最短重试间隔
+	acmeNonceRetries  = 3                              // 随机数失效重试次数
+)
+
+// ACME配置变量，可通过环境变量调整
+var (
+	acmeDirectory = getEnvAsString("NP_ACME_DIRECTORY", "https://acme-v02.api.letsencrypt.org/directory") // ACME目录地址
+	acmeHTTPAddr  = getEnvAsString("NP_ACME_HTTP_ADDR", ":80")                                            // HTTP-01验证监听地址
+	acmeCAFile    = getEnvAsString("NP_ACME_CA", "")                                                      // ACME服务端CA证书包
+)
+
+// acmeIDPeAcmeIdentifier TLS-ALPN-01验证证书扩展标识
+var acmeIDPeAcmeIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// acmeManagers 按缓存目录与域名共享的证书管理器
+var acmeManagers sync.Map
+
+// acmeDirectoryInfo ACME目录
+type acmeDirectoryInfo struct {
+	NewNonce   string `json:"newNonce"`   // 随机数地址
+	NewAccount string `json:"newAccount"` // 账户注册地址
+	NewOrder   string `json:"newOrder"`   // 订单创建地址
+}
+
+// acmeProblem ACME错误信息
+type acmeProblem struct {
+	Type   string `json:"type"`   // 错误类型
+	Detail string `json:"detail"` // 错误详情
+}
+
+// Error 格式化ACME错误信息
+func (p *acmeProblem) Error() string {
+	if p == nil {
+		return "unknown error"
+	}
+	return strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:") + ": " + p.Detail
+}
+
+// acmeOrder ACME订单
+type acmeOrder struct {
+	Status         string       `json:"status"`                // 订单状态
+	Authorizations []string     `json:"authorizations"`        // 授权地址
+	Finalize       string       `json:"finalize"`              // 完成地址
+	Certificate    string       `json:"certificate,omitempty"` // 证书地址
+	Error          *acmeProblem `json:"error,omitempty"`       // 订单错误
+}
+
+// acmeAuthorization ACME授权
+type acmeAuthorization struct {
+	Status     string `json:"status"` // 授权状态
+	Identifier struct {
+		Value string `json:"value"` // 域名
+	} `json:"identifier"`
+	Challenges []acmeChallenge `json:"challenges"` // 可用验证
+}
+
+// acmeChallenge ACME验证
+type acmeChallenge struct {
+	Type   string       `json:"type"`            // 验证类型
+	URL    string       `json:"url"`             // 验证地址
+	Token  string       `json:"token"`           // 验证令牌
+	Status string       `json:"status"`          // 验证状态
+	Error  *acmeProblem `json:"error,omitempty"` // 验证错误
+}
+
+// acmeManager ACME证书管理器
+type acmeManager struct {
+	logger     Logger                          // 日志记录器
+	domains    []string                        // 证书域名
+	email      string                          // 联系邮箱
+	certFile   string                          // 证书缓存文件
+	keyFile    string                          // 私钥缓存文件
+	client     *http.Client                    // ACME客户端
+	accountKey *ecdsa.PrivateKey               // 账户私钥
+	directory  *acmeDirectoryInfo              // ACME目录
+	kid        string                          // 账户地址
+	nonceMu    sync.Mutex                      // 随机数互斥锁
+	nonce      string                          // 可用随机数
+	cert       atomic.Pointer[tls.Certificate] // 当前证书
+	tokens     sync.Map                        // HTTP-01验证令牌
+	alpnCerts  sync.Map                        // TLS-ALPN-01验证证书
+	alpn       bool                            // 允许TLS-ALPN-01验证
+}
+
+// acmeSettings 获取ACME域名与缓存目录
+func acmeSettings(parsedURL *url.URL) ([]string, string, error) {
+	query := parsedURL.Query()
+	var domains []string
+	for domain := range strings.SplitSeq(query.Get("domain"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		if host := parsedURL.Hostname(); host != "" && net.ParseIP(host) == nil {
+			domains = append(domains, strings.ToLower(host))
+		}
+	}
+	if len(domains) == 0 {
+		return nil, "", fmt.Errorf("acmeSettings: missing domain")
+	}
+
+	cacheDir := query.Get("cache")
+	if cacheDir == "" {
+		if userCache, err := os.UserCacheDir(); err == nil {
+			cacheDir = filepath.Join(userCache, "nodepass", "acme")
+		} else {
+			cacheDir = "acme"
+		}
+	}
+	return domains, cacheDir, nil
+}
+
+// ACMECertFiles 获取ACME证书与私钥的缓存文件路径
+func ACMECertFiles(parsedURL *url.URL) (string, string, error) {
+	domains, cacheDir, err := acmeSettings(parsedURL)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(cacheDir, domains[0]+".crt"), filepath.Join(cacheDir, domains[0]+".key"), nil
+}
+
+// NewACMEConfig 创建由ACME自动签发与续期证书的TLS配置
+func NewACMEConfig(parsedURL *url.URL, logger Logger) (*tls.Config, error) {
+	domains, cacheDir, err := acmeSettings(parsedURL)
+	if err != nil {
+		return nil, fmt.Errorf("newACMEConfig: %w", err)
+	}
+
+	// 仅主控的TLS监听可响应TLS-ALPN-01验证，服务端须使用HTTP-01
+	alpn := parsedURL.Scheme == "master"
+	if !alpn && acmeHTTPAddr == "" {
+		return nil, fmt.Errorf("newACMEConfig: NP_ACME_HTTP_ADDR is required for %v instances", parsedURL.Scheme)
+	}
+
+	// 同一进程内复用管理器
+	managerKey := cacheDir + "|" + strings.Join(domains, ",")
+	if manager, ok := acmeManagers.Load(managerKey); ok {
+		return manager.(*acmeManager).tlsConfig(), nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("newACMEConfig: %w", err)
+	}
+	certFile, keyFile, _ := ACMECertFiles(parsedURL)
+
+	// 加载ACME服务端CA证书包
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if acmeCAFile != "" {
+		rootCAs, err := loadCertPool(acmeCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("newACMEConfig: %w", err)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+
+	m := &acmeManager{
+		logger:   logger,
+		domains:  domains,
+		email:    parsedURL.Query().Get("email"),
+		certFile: certFile,
+		keyFile:  keyFile,
+		client:   &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		alpn:     alpn,
+	}
+	if m.accountKey, err = loadOrCreateKey(filepath.Join(cacheDir, "account.key")); err != nil {
+		return nil, fmt.Errorf("newACMEConfig: %w", err)
+	}
+
+	// 加载缓存证书
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && cert.Leaf != nil && cert.Leaf.VerifyHostname(domains[0]) == nil {
+		m.cert.Store(&cert)
+		logger.Info("ACME cert loaded: %v until %v", domains[0], cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	manager, loaded := acmeManagers.LoadOrStore(managerKey, m)
+	if !loaded {
+		go m.renewLoop()
+	}
+	return manager.(*acmeManager).tlsConfig(), nil
+}
+
+// tlsConfig 构建使用当前证书的TLS配置
+func (m *acmeManager) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		ServerName:         m.domains[0],
+		GetCertificate:     m.getCertificate,
+		GetConfigForClient: m.getConfigForClient,
+	}
+}
+
+// getCertificate 获取当前证书
+func (m *acmeManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := m.cert.Load(); cert != nil {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("getCertificate: ACME certificate not issued yet")
+}
+
+// getConfigForClient 为TLS-ALPN-01验证请求返回验证证书
+func (m *acmeManager) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	if !slices.Contains(hello.SupportedProtos, acmeALPNProto) {
+		return nil, nil
+	}
+	cert, ok := m.alpnCerts.Load(strings.ToLower(hello.ServerName))
+	if !ok {
+		return nil, fmt.Errorf("getConfigForClient: no pending challenge for %v", hello.ServerName)
+	}
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*cert.(*tls.Certificate)},
+		NextProtos:   []string{acmeALPNProto},
+	}, nil
+}
+
+// renewLoop 证书签发与续期循环，剩余有效期不足三分之一时续期
+func (m *acmeManager) renewLoop() {
+	retry := acmeRetryMin
+	for {
+		wait := ReloadInterval
+		if renewDue(m.cert.Load()) {
+			if err := m.obtain(); err != nil {
+				m.logger.Error("ACME certificate request failed: %v", err)
+				wait = min(retry, ReloadInterval)
+				retry = min(retry*2, ReloadInterval)
+			} else {
+				cert := m.cert.Load()
+				m.logger.Info("ACME cert issued: %v until %v", m.domains[0], cert.Leaf.NotAfter.Format(time.RFC3339))
+				retry = acmeRetryMin
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// renewDue 判断证书是否需要签发或续期
+func renewDue(cert *tls.Certificate) bool {
+	if cert == nil || cert.Leaf == nil {
+		return true
+	}
+	return time.Until(cert.Leaf.NotAfter) < cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore)/3
+}
+
+// obtain 完成一次ACME订单并写入缓存
+func (m *acmeManager) obtain() error {
+	if err := m.register(); err != nil {
+		return fmt.Errorf("obtain: %w", err)
+	}
+
+	// 启动HTTP-01验证服务，失败时仅主控可使用TLS-ALPN-01
+	var httpServer *http.Server
+	if acmeHTTPAddr != "" {
+		listener, err := net.Listen("tcp", acmeHTTPAddr)
+		switch {
+		case err != nil && !m.alpn:
+			return fmt.Errorf("obtain: HTTP-01 listener unavailable: %w", err)
+		case err != nil:
+			m.logger.Warn("ACME HTTP-01 listener unavailable, using TLS-ALPN-01: %v", err)
+		default:
+			httpServer = &http.Server{Handler: http.HandlerFunc(m.serveChallenge), ReadHeaderTimeout: handshakeTimeout}
+			go httpServer.Serve(listener)
+			defer httpServer.Close()
+		}
+	}
+
+	// 创建订单
+	identifiers := make([]map[string]string, 0, len(m.domains))
+	for _, domain := range m.domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": domain})
+	}
+	var order acmeOrder
+	resp, err := m.post(m.directory.NewOrder, map[string]any{"identifiers": identifiers}, &order)
+	if err != nil {
+		return fmt.Errorf("obtain: new order failed: %w", err)
+	}
+	orderURL := resp.Header.Get("Location")
+
+	// 完成域名授权
+	for _, authzURL := range order.Authorizations {
+		if err := m.authorize(authzURL, httpServer != nil); err != nil {
+			return fmt.Errorf("obtain: %w", err)
+		}
+	}
+
+	// 生成证书私钥与签名请求
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("obtain: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.domains[0]},
+		DNSNames: m.domains,
+	}, certKey)
+	if err != nil {
+		return fmt.Errorf("obtain: %w", err)
+	}
+	if _, err := m.post(order.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr)}, &order); err != nil {
+		return fmt.Errorf("obtain: finalize failed: %w", err)
+	}
+
+	// 等待签发完成
+	deadline := time.Now().Add(acmePollTimeout)
+	for order.Status != "valid" {
+		if order.Status == "invalid" || time.Now().After(deadline) {
+			return fmt.Errorf("obtain: order %v: %v", order.Status, order.Error)
+		}
+		time.Sleep(acmePollInterval)
+		if _, err := m.post(orderURL, nil, &order); err != nil {
+			return fmt.Errorf("obtain: %w", err)
+		}
+	}
+
+	// 下载证书链
+	var chainPEM []byte
+	if _, err := m.post(order.Certificate, nil, &chainPEM); err != nil {
+		return fmt.Errorf("obtain: download failed: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return fmt.Errorf("obtain: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(chainPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("obtain: %w", err)
+	}
+
+	// 先写私钥再写证书，供按文件重载的实例使用
+	if err := writeFileAtomic(m.keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("obtain: %w", err)
+	}
+	if err := writeFileAtomic(m.certFile, chainPEM, 0644); err != nil {
+		return fmt.Errorf("obtain: %w", err)
+	}
+	m.cert.Store(&cert)
+	return nil
+}
+
+// authorize 完成单个域名授权
+func (m *acmeManager) authorize(authzURL string, useHTTP bool) error {
+	var authz acmeAuthorization
+	if _, err := m.post(authzURL, nil, &authz); err != nil {
+		return fmt.Errorf("authorize: %w", err)
+	}
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	challengeType := "tls-alpn-01"
+	if useHTTP {
+		challengeType = "http-01"
+	}
+	index := slices.IndexFunc(authz.Challenges, func(ch acmeChallenge) bool { return ch.Type == challengeType })
+	if index < 0 {
+		return fmt.Errorf("authorize: %v not offered for %v", challengeType, authz.Identifier.Value)
+	}
+	challenge := authz.Challenges[index]
+
+	// 准备验证响应
+	thumbprint, err := m.thumbprint()
+	if err != nil {
+		return fmt.Errorf("authorize: %w", err)
+	}
+	keyAuth := challenge.Token + "." + thumbprint
+	domain := authz.Identifier.Value
+	if useHTTP {
+		m.tokens.Store(challenge.Token, keyAuth)
+		defer m.tokens.Delete(challenge.Token)
+	} else {
+		cert, err := newALPNCert(domain, keyAuth)
+		if err != nil {
+			return fmt.Errorf("authorize: %w", err)
+		}
+		m.alpnCerts.Store(domain, cert)
+		defer m.alpnCerts.Delete(domain)
+	}
+	m.logger.Debug("ACME %v challenge: %v", challengeType, domain)
+
+	// 通知服务端开始验证
+	if _, err := m.post(challenge.URL, struct{}{}, nil); err != nil {
+		return fmt.Errorf("authorize: %w", err)
+	}
+
+	// 等待验证结果
+	deadline := time.Now().Add(acmePollTimeout)
+	for authz.Status != "valid" {
+		if authz.Status == "invalid" || time.Now().After(deadline) {
+			for _, ch := range authz.Challenges {
+				if ch.Error != nil {
+					return fmt.Errorf("authorize: %v %v: %v", domain, authz.Status, ch.Error)
+				}
+			}
+			return fmt.Errorf("authorize: %v %v", domain, authz.Status)
+		}
+		time.Sleep(acmePollInterval)
+		if _, err := m.post(authzURL, nil, &authz); err != nil {
+			return fmt.Errorf("authorize: %w", err)
+		}
+	}
+	return nil
+}
+
+// serveChallenge 响应HTTP-01验证请求
+func (m *acmeManager) serveChallenge(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutPrefix(r.URL.Path, acmeChallengePath)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	keyAuth, ok := m.tokens.Load(token)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(keyAuth.(string)))
+}
+
+// newALPNCert 生成TLS-ALPN-01验证证书
+func newALPNCert(domain, keyAuth string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("newALPNCert: %w", err)
+	}
+	digest := sha256.Sum256([]byte(keyAuth))
+	extValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("newALPNCert: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: acmeIDPeAcmeIdentifier, Critical: true, Value: extValue},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("newALPNCert: %w", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// register 获取ACME目录并注册或找回账户
+func (m *acmeManager) register() error {
+	if m.directory == nil {
+		resp, err := m.client.Get(acmeDirectory)
+		if err != nil {
+			return fmt.Errorf("register: %w", err)
+		}
+		defer resp.Body.Close()
+		var directory acmeDirectoryInfo
+		if err := json.NewDecoder(resp.Body).Decode(&directory); err != nil {
+			return fmt.Errorf("register: decode directory failed: %w", err)
+		}
+		m.directory = &directory
+	}
+	if m.kid != "" {
+		return nil
+	}
+
+	account := map[string]any{"termsOfServiceAgreed": true}
+	if m.email != "" {
+		account["contact"] = []string{"mailto:" + m.email}
+	}
+	resp, err := m.post(m.directory.NewAccount, account, nil)
+	if err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+	m.kid = resp.Header.Get("Location")
+	if m.kid == "" {
+		return fmt.Errorf("register: missing account location")
+	}
+	return nil
+}
+
+// jwk 获取账户公钥的JWK表示，字段按字典序排列
+func (m *acmeManager) jwk() (map[string]string, error) {
+	pub, err := m.accountKey.PublicKey.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("jwk: %w", err)
+	}
+	point := pub.Bytes()
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   base64.RawURLEncoding.EncodeToString(point[1:33]),
+		"y":   base64.RawURLEncoding.EncodeToString(point[33:]),
+	}, nil
+}
+
+// thumbprint 计算账户公钥指纹
+func (m *acmeManager) thumbprint() (string, error) {
+	jwk, err := m.jwk()
+	if err != nil {
+		return "", fmt.Errorf("thumbprint: %w", err)
+	}
+	data, _ := json.Marshal(jwk)
+	digest := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(digest[:]), nil
+}
+
+// getNonce 获取可用随机数
+func (m *acmeManager) getNonce() (string, error) {
+	m.nonceMu.Lock()
+	nonce := m.nonce
+	m.nonce = ""
+	m.nonceMu.Unlock()
+	if nonce != "" {
+		return nonce, nil
+	}
+
+	resp, err := m.client.Head(m.directory.NewNonce)
+	if err != nil {
+		return "", fmt.Errorf("getNonce: %w", err)
+	}
+	resp.Body.Close()
+	if nonce = resp.Header.Get("Replay-Nonce"); nonce == "" {
+		return "", fmt.Errorf("getNonce: missing Replay-Nonce")
+	}
+	return nonce, nil
+}
+
+// sign 以ES256签名JWS请求
+func (m *acmeManager) sign(targetURL string, payload any) ([]byte, error) {
+	nonce, err := m.getNonce()
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+	protected := map[string]any{"alg": "ES256", "nonce": nonce, "url": targetURL}
+	if m.kid != "" {
+		protected["kid"] = m.kid
+	} else if protected["jwk"], err = m.jwk(); err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+
+	// 空载荷表示POST-as-GET
+	var payloadB64 string
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("sign: %w", err)
+		}
+		payloadB64 = base64.RawURLEncoding.EncodeToString(data)
+	}
+	protectedData, _ := json.Marshal(protected)
+	protectedB64 := base64.RawURLEncoding.EncodeToString(protectedData)
+
+	digest := sha256.Sum256([]byte(protectedB64 + "." + payloadB64))
+	r, s, err := ecdsa.Sign(rand.Reader, m.accountKey, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("sign: %w", err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": protectedB64,
+		"payload":   payloadB64,
+		"signature": base64.RawURLEncoding.EncodeToString(signature),
+	})
+}
+
+// post 发送签名请求，随机数失效时重试，result非空时读取响应
+func (m *acmeManager) post(targetURL string, payload, result any) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := m.sign(targetURL, payload)
+		if err != nil {
+			return nil, fmt.Errorf("post: %w", err)
+		}
+		resp, err := m.client.Post(targetURL, "application/jose+json", bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("post: %w", err)
+		}
+		if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+			m.nonceMu.Lock()
+			m.nonce = nonce
+			m.nonceMu.Unlock()
+		}
+
+		if resp.StatusCode >= http.StatusBadRequest {
+			var problem acmeProblem
+			json.NewDecoder(resp.Body).Decode(&problem)
+			resp.Body.Close()
+			if problem.Type == "urn:ietf:params:acme:error:badNonce" && attempt < acmeNonceRetries {
+				continue
+			}
+			return nil, fmt.Errorf("post: status %d: %v", resp.StatusCode, &problem)
+		}
+
+		// 证书链按原始内容读取，其余按JSON解析
+		defer resp.Body.Close()
+		switch result := result.(type) {
+		case nil:
+		case *[]byte:
+			if *result, err = io.ReadAll(resp.Body); err != nil {
+				return nil, fmt.Errorf("post: %w", err)
+			}
+		default:
+			if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+				return nil, fmt.Errorf("post: decode failed: %w", err)
+			}
+		}
+		return resp, nil
+	}
+}
+
+// loadOrCreateKey 加载或生成ECDSA私钥
+func loadOrCreateKey(keyFile string) (*ecdsa.PrivateKey, error) {
+	if keyPEM, err := os.ReadFile(keyFile); err == nil {
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, fmt.Errorf("loadOrCreateKey: invalid key file %v", keyFile)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("loadOrCreateKey: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("loadOrCreateKey: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("loadOrCreateKey: %w", err)
+	}
+	if err := writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("loadOrCreateKey: %w", err)
+	}
+	return key, nil
+}
+
+// writeFileAtomic 经临时文件原子写入
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	tmpFile := name + ".tmp"
+	if err := os.WriteFile(tmpFile, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, name)
+}
@@ -0,0 +1,504 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NodePassProject/logs"
+)
+
+// fakeACME 最小化的ACME服务端，校验JWS签名与随机数并同步完成验证
+type fakeACME struct {
+	t        *testing.T
+	mu       sync.Mutex
+	srv      *httptest.Server
+	nonces   map[string]bool
+	accounts map[string]*ecdsa.PublicKey
+	thumbs   map[string]string
+	authz    map[string]map[string]any
+	orders   map[string]map[string]any
+	certs    map[string][]byte
+	caKey    *ecdsa.PrivateKey
+	caCert   *x509.Certificate
+	httpAddr string
+	tlsAddr  string
+	seq      int
+	badNonce int
+}
+
+// newFakeACME 启动ACME服务端并将包内ACME配置指向它
+func newFakeACME(t *testing.T) *fakeACME {
+	f := &fakeACME{
+		t:        t,
+		nonces:   map[string]bool{},
+		accounts: map[string]*ecdsa.PublicKey{},
+		thumbs:   map[string]string{},
+		authz:    map[string]map[string]any{},
+		orders:   map[string]map[string]any{},
+		certs:    map[string][]byte{},
+	}
+	var err error
+	if f.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake acme ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &f.caKey.PublicKey, f.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.caCert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	f.srv = httptest.NewTLSServer(f)
+	t.Cleanup(f.srv.Close)
+
+	caFile := filepath.Join(t.TempDir(), "acme-ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	directory, ca, httpAddr := acmeDirectory, acmeCAFile, acmeHTTPAddr
+	t.Cleanup(func() { acmeDirectory, acmeCAFile, acmeHTTPAddr = directory, ca, httpAddr })
+	acmeDirectory = f.srv.URL + "/dir"
+	acmeCAFile = caFile
+	return f
+}
+
+// roots 返回签发证书的根证书池
+func (f *fakeACME) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(f.caCert)
+	return pool
+}
+
+// issueNonce 下发新随机数
+func (f *fakeACME) issueNonce(w http.ResponseWriter) {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+	f.nonces[nonce] = true
+	w.Header().Set("Replay-Nonce", nonce)
+}
+
+// verify 校验JWS请求，返回账户地址与载荷
+func (f *fakeACME) verify(r *http.Request) (string, []byte, string) {
+	var jws struct{ Protected, Payload, Signature string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return "", nil, "malformed"
+	}
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		Jwk                  map[string]string
+	}
+	json.Unmarshal(header, &protected)
+	if protected.Alg != "ES256" {
+		return "", nil, "badSignatureAlgorithm"
+	}
+	if !f.nonces[protected.Nonce] {
+		return "", nil, "badNonce"
+	}
+	delete(f.nonces, protected.Nonce)
+	if f.badNonce > 0 {
+		f.badNonce--
+		return "", nil, "badNonce"
+	}
+	if protected.URL != f.srv.URL+r.URL.Path {
+		return "", nil, "malformed"
+	}
+
+	var kid string
+	var pub *ecdsa.PublicKey
+	switch {
+	case protected.Kid != "":
+		kid, pub = protected.Kid, f.accounts[protected.Kid]
+		if pub == nil {
+			return "", nil, "accountDoesNotExist"
+		}
+	case r.URL.Path == "/new-acct":
+		x, _ := base64.RawURLEncoding.DecodeString(protected.Jwk["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.Jwk["y"])
+		pub = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		thumbprint := rfc7638Thumbprint(pub)
+		kid = f.srv.URL + "/acct/" + thumbprint[:8]
+		f.accounts[kid] = pub
+		f.thumbs[kid] = thumbprint
+	default:
+		return "", nil, "malformed"
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(signature) != 64 || !ecdsa.Verify(pub, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+		return "", nil, "malformed"
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return kid, payload, ""
+}
+
+// problem 返回ACME错误
+func problem(w http.ResponseWriter, status int, kind string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"type": "urn:ietf:params:acme:error:" + kind, "detail": kind})
+}
+
+// ServeHTTP 处理ACME请求
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	base := f.srv.URL
+	switch r.URL.Path {
+	case "/dir":
+		json.NewEncoder(w).Encode(map[string]string{"newNonce": base + "/nonce", "newAccount": base + "/new-acct", "newOrder": base + "/new-order"})
+		return
+	case "/nonce":
+		f.issueNonce(w)
+		return
+	}
+
+	kid, payload, kind := f.verify(r)
+	f.issueNonce(w)
+	if kind != "" {
+		problem(w, http.StatusBadRequest, kind)
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/new-acct":
+		w.Header().Set("Location", kid)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"status":"valid"}`))
+	case path == "/new-order":
+		var req struct{ Identifiers []map[string]string }
+		json.Unmarshal(payload, &req)
+		f.seq++
+		orderID := fmt.Sprint(f.seq)
+		var authzURLs []string
+		for _, id := range req.Identifiers {
+			f.seq++
+			authzID := fmt.Sprint(f.seq)
+			token := base64.RawURLEncoding.EncodeToString([]byte("token-" + authzID))
+			f.authz[authzID] = map[string]any{
+				"status":     "pending",
+				"identifier": map[string]string{"type": "dns", "value": id["value"]},
+				"challenges": []map[string]string{
+					{"type": "http-01", "url": base + "/chal/" + authzID + "/http-01", "token": token, "status": "pending"},
+					{"type": "tls-alpn-01", "url": base + "/chal/" + authzID + "/tls-alpn-01", "token": token, "status": "pending"},
+				},
+			}
+			authzURLs = append(authzURLs, base+"/authz/"+authzID)
+		}
+		f.orders[orderID] = map[string]any{"status": "pending", "authorizations": authzURLs, "finalize": base + "/finalize/" + orderID}
+		w.Header().Set("Location", base+"/order/"+orderID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.orders[orderID])
+	case strings.HasPrefix(path, "/authz/"):
+		if len(payload) != 0 {
+			problem(w, http.StatusBadRequest, "malformed")
+			return
+		}
+		json.NewEncoder(w).Encode(f.authz[strings.TrimPrefix(path, "/authz/")])
+	case strings.HasPrefix(path, "/chal/"):
+		parts := strings.Split(path, "/")
+		authz := f.authz[parts[2]]
+		token := authz["challenges"].([]map[string]string)[0]["token"]
+		domain := authz["identifier"].(map[string]string)["value"]
+		if err := f.validate(parts[3], domain, token+"."+f.thumbs[kid]); err != nil {
+			f.t.Logf("validation failed: %v", err)
+			authz["status"] = "invalid"
+		} else {
+			authz["status"] = "valid"
+		}
+		json.NewEncoder(w).Encode(map[string]string{"type": parts[3], "status": "processing"})
+	case strings.HasPrefix(path, "/finalize/"):
+		orderID := strings.TrimPrefix(path, "/finalize/")
+		order := f.orders[orderID]
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || csr.CheckSignature() != nil {
+			problem(w, http.StatusBadRequest, "badCSR")
+			return
+		}
+		for _, authzURL := range order["authorizations"].([]string) {
+			if f.authz[authzURL[strings.LastIndex(authzURL, "/")+1:]]["status"] != "valid" {
+				problem(w, http.StatusForbidden, "orderNotReady")
+				return
+			}
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(f.seq + 100)),
+			Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		certDER, _ := x509.CreateCertificate(rand.Reader, template, f.caCert, csr.PublicKey, f.caKey)
+		f.certs[orderID] = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw})...)
+		order["status"] = "processing"
+		order["certificate"] = base + "/cert/" + orderID
+		json.NewEncoder(w).Encode(order)
+		order["status"] = "valid"
+	case strings.HasPrefix(path, "/order/"):
+		json.NewEncoder(w).Encode(f.orders[strings.TrimPrefix(path, "/order/")])
+	case strings.HasPrefix(path, "/cert/"):
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		w.Write(f.certs[strings.TrimPrefix(path, "/cert/")])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// validate 按验证类型检查客户端响应
+func (f *fakeACME) validate(challengeType, domain, keyAuth string) error {
+	if challengeType == "http-01" {
+		token, _, _ := strings.Cut(keyAuth, ".")
+		resp, err := http.Get("http://" + f.httpAddr + acmeChallengePath + token)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != keyAuth {
+			return fmt.Errorf("http-01 body %q, want %q", body, keyAuth)
+		}
+		return nil
+	}
+
+	conn, err := tls.Dial("tcp", f.tlsAddr, &tls.Config{ServerName: domain, NextProtos: []string{acmeALPNProto}, InsecureSkipVerify: true})
+	if err != nil {
+		return err
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+	if state.NegotiatedProtocol != acmeALPNProto {
+		return fmt.Errorf("tls-alpn-01 negotiated %q", state.NegotiatedProtocol)
+	}
+	leaf := state.PeerCertificates[0]
+	digest := sha256.Sum256([]byte(keyAuth))
+	want, _ := asn1.Marshal(digest[:])
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(acmeIDPeAcmeIdentifier) && ext.Critical && bytes.Equal(ext.Value, want) && leaf.DNSNames[0] == domain {
+			return nil
+		}
+	}
+	return fmt.Errorf("tls-alpn-01 certificate mismatch")
+}
+
+// rfc7638Thumbprint 按RFC 7638独立计算EC公钥指纹
+func rfc7638Thumbprint(pub *ecdsa.PublicKey) string {
+	x := base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32)))
+	y := base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))
+	digest := sha256.Sum256(fmt.Appendf(nil, `{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, x, y))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// freeAddr 获取空闲的本地地址
+func freeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitCert 等待证书签发并按域名校验
+func waitCert(t *testing.T, config *tls.Config, roots *x509.CertPool, name string) {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if cert, err := config.GetCertificate(&tls.ClientHelloInfo{}); err == nil {
+			if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots}); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	t.Fatal("certificate not issued")
+}
+
+func TestACMEHTTP01(t *testing.T) {
+	f := newFakeACME(t)
+	f.badNonce = 1
+	f.httpAddr = freeAddr(t)
+	acmeHTTPAddr = f.httpAddr
+
+	parsedURL, _ := url.Parse("server://0.0.0.0:10101/127.0.0.1:8080?domain=a.test,www.a.test&email=ops@a.test&cache=" + t.TempDir())
+	config, err := NewACMEConfig(parsedURL, logs.NewLogger(logs.Debug, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitCert(t, config, f.roots(), "www.a.test")
+	if f.badNonce != 0 {
+		t.Fatal("badNonce was not retried")
+	}
+
+	certFile, keyFile, _ := ACMECertFiles(parsedURL)
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("cached certificate: %v", err)
+	}
+	shared, err := NewACMEConfig(parsedURL, logs.NewLogger(logs.Debug, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Fatalf("shared manager: %v", err)
+	}
+}
+
+func TestACMETLSALPN01(t *testing.T) {
+	f := newFakeACME(t)
+	acmeHTTPAddr = ""
+
+	// 先绑定监听，保证验证发起时地址可用
+	rawListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rawListener.Close()
+	f.tlsAddr = rawListener.Addr().String()
+
+	cacheDir := t.TempDir()
+	parsedURL, _ := url.Parse("master://b.test:9090?cache=" + cacheDir)
+	config, err := NewACMEConfig(parsedURL, logs.NewLogger(logs.Debug, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := tls.NewListener(rawListener, config)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	waitCert(t, config, f.roots(), "b.test")
+
+	// 新管理器加载缓存证书
+	acmeManagers.Delete(cacheDir + "|b.test")
+	cached, err := NewACMEConfig(parsedURL, logs.NewLogger(logs.Debug, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.GetCertificate(&tls.ClientHelloInfo{}); err != nil {
+		t.Fatalf("cached certificate: %v", err)
+	}
+}
+
+func TestACMEServerRequiresHTTP01(t *testing.T) {
+	newFakeACME(t)
+	acmeHTTPAddr = ""
+
+	parsedURL, _ := url.Parse("server://0.0.0.0:443/127.0.0.1:8080?domain=c.test&cache=" + t.TempDir())
+	if _, err := NewACMEConfig(parsedURL, logs.NewLogger(logs.Debug, false)); err == nil {
+		t.Fatal("server with empty NP_ACME_HTTP_ADDR accepted")
+	}
+}
+
+func TestACMESign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &acmeManager{accountKey: key, nonce: "nonce-1"}
+
+	thumbprint, err := m.thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := rfc7638Thumbprint(&key.PublicKey); thumbprint != want {
+		t.Fatalf("thumbprint %v, want %v", thumbprint, want)
+	}
+
+	for _, kid := range []string{"", "https://acme.test/acct/1"} {
+		m.kid, m.nonce = kid, "nonce-1"
+		body, err := m.sign("https://acme.test/new-order", map[string]string{"k": "v"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var jws struct{ Protected, Payload, Signature string }
+		if err := json.Unmarshal(body, &jws); err != nil {
+			t.Fatal(err)
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+		digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+		if len(signature) != 64 || !ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			t.Fatal("signature does not verify")
+		}
+
+		header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+		var protected map[string]any
+		json.Unmarshal(header, &protected)
+		if protected["alg"] != "ES256" || protected["nonce"] != "nonce-1" || protected["url"] != "https://acme.test/new-order" {
+			t.Fatalf("protected header %v", protected)
+		}
+		_, hasJWK := protected["jwk"]
+		if (kid == "") != hasJWK || kid != "" && protected["kid"] != kid {
+			t.Fatalf("kid %q: protected header %v", kid, protected)
+		}
+	}
+}
+
+func TestACMERenewDue(t *testing.T) {
+	now := time.Now()
+	leaf := func(notBefore, notAfter time.Time) *tls.Certificate {
+		return &tls.Certificate{Leaf: &x509.Certificate{NotBefore: notBefore, NotAfter: notAfter}}
+	}
+	tests := []struct {
+		name string
+		cert *tls.Certificate
+		want bool
+	}{
+		{"missing", nil, true},
+		{"fresh", leaf(now.Add(-time.Hour), now.Add(90*24*time.Hour)), false},
+		{"two thirds left", leaf(now.Add(-30*24*time.Hour), now.Add(60*24*time.Hour)), false},
+		{"under a third left", leaf(now.Add(-61*24*time.Hour), now.Add(29*24*time.Hour)), true},
+		{"expired", leaf(now.Add(-91*24*time.Hour), now.Add(-24*time.Hour)), true},
+	}
+	for _, tt := range tests {
+		if got := renewDue(tt.cert); got != tt.want {
+			t.Errorf("%v: renewDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// 内部包，实现主控API审计日志
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// 审计日志常量
+const (
+	auditFileName      = "audit.log"                  // 审计日志文件名
+	auditMaxSize       = 10 << 20                     // 单个审计日志文件上限
+	auditArchiveLayout = "20060102T150405.000000000Z" // 归档文件时间戳格式
+	auditDefaultLimit  = 100                          // 默认查询条数
+	auditMaxLimit      = 1000                         // 最大查询条数
+	redactedValue      = "********"                   // 脱敏占位符
+)
+
+// auditContext 请求上下文中审计条目的键
+type auditContext struct{}
+
+// AuditEntry 审计日志条目
+type AuditEntry struct {
+	Time     time.Time      `json:"time"`               // 操作时间
+	KeyID    string         `json:"key_id"`             // Key ID
+	KeyName  string         `json:"key_name"`           // Key名称
+	Source   string         `json:"source"`             // 来源IP
+	Method   string         `json:"method"`             // 请求方法
+	Endpoint string         `json:"endpoint"`           // 请求端点
+	Instance string         `json:"instance,omitempty"` // 实例ID
+	Action   string         `json:"action,omitempty"`   // 实例操作
+	Status   int            `json:"status"`             // 响应状态码
+	Before   map[string]any `json:"before,omitempty"`   // 变更前字段
+	After    map[string]any `json:"after,omitempty"`    // 变更后字段
+}
+
+// auditRecorder 记录响应状态码
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader 记录状态码并写入响应头
+func (r *auditRecorder) WriteHeader(statusCode int) {
+	r.status = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// auditEntry 获取请求对应的审计条目
+func auditEntry(r *http.Request) *AuditEntry {
+	entry, _ := r.Context().Value(auditContext{}).(*AuditEntry)
+	return entry
+}
+
+// auditTrack 记录审计条目关联的实例及变更前字段
+func auditTrack(r *http.Request, id string, before map[string]any) {
+	if entry := auditEntry(r); entry != nil {
+		entry.Instance = id
+		entry.Before = before
+	}
+}
+
+// auditAction 记录审计条目的实例操作
+func auditAction(r *http.Request, action string) {
+	if entry := auditEntry(r); entry != nil {
+		entry.Action = action
+	}
+}
+
+// auditResult 记录审计条目的变更后字段
+func auditResult(r *http.Request, after map[string]any) {
+	if entry := auditEntry(r); entry != nil {
+		entry.After = after
+	}
+}
+
+// auditSnapshot 获取实例可审计字段快照，敏感信息已脱敏
+func auditSnapshot(instance *Instance) map[string]any {
+	snapshot := map[string]any{
+		"alias":   instance.Alias,
+		"url":     redactURL(instance.URL),
+		"restart": instance.Restart,
+		"peer":    instance.Meta.Peer,
+		"tags":    maps.Clone(instance.Meta.Tags),
+		"quota":   nil,
+	}
+	if instance.ID == apiKeyID {
+		snapshot["url"] = redactedValue
+	}
+	if quota := instance.Quota; quota != nil {
+		snapshot["quota"] = map[string]any{
+			"limit":    quota.Limit,
+			"cycle":    quota.Cycle,
+			"day":      quota.Day,
+			"hour":     quota.Hour,
+			"action":   quota.Action,
+			"throttle": quota.Throttle,
+		}
+	}
+	return snapshot
+}
+
+// redactURL 隐藏URL中的隧道密钥
+func redactURL(rawURL string) string {
+	parsedURL, err := ParseURL(rawURL)
+	if err != nil {
+		return redactedValue
+	}
+	if parsedURL.User != nil {
+		parsedURL.User = url.User(redactedValue)
+	}
+	return parsedURL.String()
+}
+
+// newAuditEntry 为变更请求创建审计条目
+func (m *Master) newAuditEntry(r *http.Request, key *APIKey) (*http.Request, *AuditEntry) {
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	entry := &AuditEntry{
+		Time:     time.Now(),
+		Source:   source,
+		Method:   r.Method,
+		Endpoint: r.URL.Path,
+		KeyID:    key.ID,
+		KeyName:  key.Name,
+	}
+	return r.WithContext(context.WithValue(r.Context(), auditContext{}, entry)), entry
+}
+
+// writeAudit 仅保留变更字段并追加写入审计日志
+func (m *Master) writeAudit(entry *AuditEntry) {
+	for field, before := range entry.Before {
+		if after, ok := entry.After[field]; ok && reflect.DeepEqual(before, after) {
+			delete(entry.Before, field)
+			delete(entry.After, field)
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		m.logger.Error("writeAudit: marshal failed: %v", err)
+		return
+	}
+
+	m.auditMu.Lock()
+	defer m.auditMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(m.auditPath), 0755); err != nil {
+		m.logger.Error("writeAudit: mkdirAll failed: %v", err)
+		return
+	}
+	m.rotateAudit()
+	file, err := os.OpenFile(m.auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		m.logger.Error("writeAudit: open file failed: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		m.logger.Error("writeAudit: write failed: %v", err)
+	}
+}
+
+// rotateAudit 审计日志超过上限时按时间戳归档，归档文件不删除，调用方需持有auditMu
+func (m *Master) rotateAudit() {
+	info, err := os.Stat(m.auditPath)
+	if err != nil || info.Size() < auditMaxSize {
+		return
+	}
+	archive := m.auditPath + "." + time.Now().UTC().Format(auditArchiveLayout)
+	if err := os.Rename(m.auditPath, archive); err != nil {
+		m.logger.Error("rotateAudit: rename failed: %v", err)
+	}
+}
+
+// openAudit 按时间顺序打开归档与当前审计日志，返回当前可读取的长度快照
+func (m *Master) openAudit() ([]io.Reader, func(), error) {
+	m.auditMu.Lock()
+	defer m.auditMu.Unlock()
+
+	archives, err := filepath.Glob(m.auditPath + ".*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("openAudit: %w", err)
+	}
+	slices.Sort(archives)
+
+	var files []*os.File
+	var readers []io.Reader
+	closeAll := func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+	for _, path := range append(archives, m.auditPath) {
+		file, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			closeAll()
+			return nil, nil, fmt.Errorf("openAudit: open file failed: %w", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			closeAll()
+			return nil, nil, fmt.Errorf("openAudit: stat file failed: %w", err)
+		}
+		files = append(files, file)
+		readers = append(readers, io.LimitReader(file, info.Size()))
+	}
+	return readers, closeAll, nil
+}
+
+// handleAudit 处理审计日志查询请求
+func (m *Master) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if requestKey(r).scoped() {
+		httpError(w, "Forbidden: scoped key", http.StatusForbidden)
+		return
+	}
+
+	// 解析查询条件
+	query := r.URL.Query()
+	limit := auditDefaultLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			httpError(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, auditMaxLimit)
+	}
+	var since, until time.Time
+	for name, t := range map[string]*time.Time{"since": &since, "until": &until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				httpError(w, fmt.Sprintf("Invalid %s time", name), http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	instanceID, keyID := query.Get("instance"), query.Get("key")
+
+	// 持锁仅打开文件，扫描时不阻塞写入
+	entries := []*AuditEntry{}
+	readers, closeAll, err := m.openAudit()
+	if err != nil {
+		m.logger.Error("handleAudit: %v", err)
+		httpError(w, "Audit log unavailable", http.StatusInternalServerError)
+		return
+	}
+	defer closeAll()
+
+	// 顺序扫描并保留最近的匹配条目
+	scanner := bufio.NewScanner(io.MultiReader(readers...))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if instanceID != "" && entry.Instance != instanceID ||
+			keyID != "" && entry.KeyID != keyID ||
+			!since.IsZero() && entry.Time.Before(since) ||
+			!until.IsZero() && entry.Time.After(until) {
+			continue
+		}
+		entries = append(entries, &entry)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// auditable 判断请求是否需要审计
+func auditable(r *http.Request) bool {
+	return r.Method != http.MethodGet && r.Method != http.MethodHead
+}
@@ -0,0 +1,152 @@
+// 内部包，实现抗重放的握手认证
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 握手认证常量
+const (
+	authScheme          = "NodePass"             // 认证方案
+	authVersion         = "nodepass-auth-v2"     // 认证消息版本
+	authChallengeHeader = "X-NodePass-Challenge" // 服务端挑战头部
+	authChallengeSize   = 8 + 16 + 16            // 挑战长度：时间戳、随机数与校验码
+)
+
+// authSecret 进程级挑战签名密钥
+var authSecret = func() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}()
+
+// challengeTag 计算挑战校验码，绑定隧道地址
+func (c *Common) challengeTag(body []byte) []byte {
+	mac := hmac.New(sha256.New, authSecret)
+	mac.Write([]byte(c.tunnelAddr))
+	mac.Write(body)
+	return mac.Sum(nil)[:16]
+}
+
+// issueChallenge 签发无状态握手挑战，由签发时间、随机数和校验码组成
+func (c *Common) issueChallenge() (string, error) {
+	challenge := make([]byte, authChallengeSize)
+	binary.BigEndian.PutUint64(challenge, uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(challenge[8:24]); err != nil {
+		return "", fmt.Errorf("issueChallenge: %w", err)
+	}
+	copy(challenge[24:], c.challengeTag(challenge[:24]))
+	return hex.EncodeToString(challenge), nil
+}
+
+// checkChallenge 校验挑战由本进程签发且未过期，返回签发时间
+func (c *Common) checkChallenge(challenge string) (time.Time, error) {
+	raw, err := hex.DecodeString(challenge)
+	if err != nil || len(raw) != authChallengeSize || !hmac.Equal(raw[24:], c.challengeTag(raw[:24])) {
+		return time.Time{}, fmt.Errorf("unknown challenge")
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(raw)))
+	if age := time.Since(issued); age < 0 || age > authSkew {
+		return time.Time{}, fmt.Errorf("expired challenge")
+	}
+	return issued, nil
+}
+
+// consumeChallenge 将挑战记入重放缓存，已使用时返回false，并清理过期条目
+func (c *Common) consumeChallenge(challenge string, issued time.Time) bool {
+	now := time.Now()
+	c.authChallenges.Range(func(key, value any) bool {
+		if now.Sub(value.(time.Time)) > authSkew {
+			c.authChallenges.Delete(key)
+		}
+		return true
+	})
+	_, used := c.authChallenges.LoadOrStore(challenge, issued)
+	return !used
+}
+
+// authMAC 计算绑定时间、服务端名称、服务端挑战与控制信道随机数的认证码
+func (c *Common) authMAC(timestamp, host, challenge, clientNonce string) string {
+	mac := hmac.New(sha256.New, []byte(c.tunnelKey))
+	mac.Write([]byte(strings.Join([]string{authVersion, timestamp, host, challenge, clientNonce}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// generateAuthToken 生成认证令牌，格式为时间戳.挑战.认证码
+func (c *Common) generateAuthToken(host, challenge, clientNonce string) string {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	return authScheme + " " + timestamp + "." + challenge + "." + c.authMAC(timestamp, host, challenge, clientNonce)
+}
+
+// expectedHost 返回令牌应绑定的服务端名称，未通过sni配置名称时沿用请求中的名称
+func (c *Common) expectedHost(r *http.Request) (string, error) {
+	if c.authHost == "" {
+		return r.Host, nil
+	}
+	host := r.Host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	if host != c.authHost {
+		return "", fmt.Errorf("host mismatch: %v", r.Host)
+	}
+	return c.authHost, nil
+}
+
+// verifyAuthToken 验证认证令牌，挑战仅在验证成功后记为已使用
+func (c *Common) verifyAuthToken(r *http.Request) error {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), authScheme+" ")
+	parts := strings.Split(token, ".")
+	if !ok || len(parts) != 3 {
+		return fmt.Errorf("malformed token")
+	}
+	timestamp, challenge, mac := parts[0], parts[1], parts[2]
+
+	issued, err := c.checkChallenge(challenge)
+	if err != nil {
+		return err
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed timestamp")
+	}
+	if skew := time.Since(time.Unix(unix, 0)); math.Abs(skew.Seconds()) > authSkew.Seconds() {
+		return fmt.Errorf("clock skew %v exceeds %v", skew.Round(time.Second), authSkew)
+	}
+
+	host, err := c.expectedHost(r)
+	if err != nil {
+		return err
+	}
+	expected := c.authMAC(timestamp, host, challenge, r.Header.Get(controlNonceHeader))
+	if !hmac.Equal([]byte(mac), []byte(expected)) {
+		return fmt.Errorf("invalid token")
+	}
+	if !c.consumeChallenge(challenge, issued) {
+		return fmt.Errorf("challenge already used")
+	}
+	return nil
+}
+
+// rejectHandshake 记录被拒绝的握手并上报事件
+func (c *Common) rejectHandshake(remoteAddr string, reason error) {
+	total := atomic.AddUint64(&c.authRejects, 1)
+	message := fmt.Sprintf("Handshake rejected from %v: %v (total %v)", remoteAddr, reason, total)
+	c.logger.Warn("tunnelHandshake: %v", message)
+	if ipc := getIPC(); ipc != nil {
+		ipc.send(&IPCMessage{Type: ipcTypeEvent, Event: ipcEventAuthReject, Message: message})
+	}
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// authRequest 构造携带认证令牌与控制信道随机数的握手请求
+func authRequest(host, token, clientNonce string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Host = host
+	r.Header.Set("Authorization", token)
+	r.Header.Set(controlNonceHeader, clientNonce)
+	return r
+}
+
+// agedChallenge 签发指定时间的挑战，校验码与正常签发一致
+func agedChallenge(c *Common, issued time.Time) string {
+	challenge := make([]byte, authChallengeSize)
+	binary.BigEndian.PutUint64(challenge, uint64(issued.UnixNano()))
+	copy(challenge[24:], c.challengeTag(challenge[:24]))
+	return hex.EncodeToString(challenge)
+}
+
+func TestAuthToken(t *testing.T) {
+	const host, nonce = "tunnel.example.com:10101", "client-nonce"
+	tests := []struct {
+		name     string
+		authHost string
+		reqHost  string
+		build    func(server, client *Common) string
+		wantErr  bool
+	}{
+		{"valid", "", host, func(server, client *Common) string {
+			challenge, _ := server.issueChallenge()
+			return client.generateAuthToken(host, challenge, nonce)
+		}, false},
+		{"valid with sni", "tunnel.example.com", host, func(server, client *Common) string {
+			challenge, _ := server.issueChallenge()
+			return client.generateAuthToken("tunnel.example.com", challenge, nonce)
+		}, false},
+		{"wrong host", "tunnel.example.com", "other.example.com:10101", func(server, client *Common) string {
+			challenge, _ := server.issueChallenge()
+			return client.generateAuthToken("tunnel.example.com", challenge, nonce)
+		}, true},
+		{"host not bound", "", host, func(server, client *Common) string {
+			challenge, _ := server.issueChallenge()
+			return client.generateAuthToken("other.example.com:10101", challenge, nonce)
+		}, true},
+		{"expired challenge", "", host, func(server, client *Common) string {
+			challenge := agedChallenge(server, time.Now().Add(-authSkew-time.Second))
+			return client.generateAuthToken(host, challenge, nonce)
+		}, true},
+		{"future challenge", "", host, func(server, client *Common) string {
+			challenge := agedChallenge(server, time.Now().Add(time.Minute))
+			return client.generateAuthToken(host, challenge, nonce)
+		}, true},
+		{"challenge from other tunnel", "", host, func(server, client *Common) string {
+			other := &Common{tunnelAddr: "0.0.0.0:20202", tunnelKey: server.tunnelKey}
+			challenge, _ := other.issueChallenge()
+			return client.generateAuthToken(host, challenge, nonce)
+		}, true},
+		{"wrong tunnel key", "", host, func(server, _ *Common) string {
+			challenge, _ := server.issueChallenge()
+			return (&Common{tunnelKey: "other"}).generateAuthToken(host, challenge, nonce)
+		}, true},
+		{"control nonce swapped", "", host, func(server, client *Common) string {
+			challenge, _ := server.issueChallenge()
+			return client.generateAuthToken(host, challenge, "other-nonce")
+		}, true},
+		{"clock skew", "", host, func(server, client *Common) string {
+			challenge, _ := server.issueChallenge()
+			timestamp := strconv.FormatInt(time.Now().Add(-authSkew-time.Minute).Unix(), 10)
+			return authScheme + " " + timestamp + "." + challenge + "." + client.authMAC(timestamp, host, challenge, nonce)
+		}, true},
+		{"malformed", "", host, func(_, _ *Common) string {
+			return authScheme + " not-a-token"
+		}, true},
+	}
+	for _, tt := range tests {
+		server := &Common{tunnelAddr: "0.0.0.0:10101", tunnelKey: "secret", authHost: tt.authHost}
+		client := &Common{tunnelKey: "secret"}
+		err := server.verifyAuthToken(authRequest(tt.reqHost, tt.build(server, client), nonce))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: verifyAuthToken error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAuthChallengeReuse(t *testing.T) {
+	const host, nonce = "tunnel.example.com:10101", "client-nonce"
+	server := &Common{tunnelAddr: "0.0.0.0:10101", tunnelKey: "secret"}
+	client := &Common{tunnelKey: "secret"}
+	challenge, err := server.issueChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 认证失败的尝试不消耗挑战
+	forged := (&Common{tunnelKey: "other"}).generateAuthToken(host, challenge, nonce)
+	if err := server.verifyAuthToken(authRequest(host, forged, nonce)); err == nil {
+		t.Fatal("forged token accepted")
+	}
+
+	token := client.generateAuthToken(host, challenge, nonce)
+	if err := server.verifyAuthToken(authRequest(host, token, nonce)); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := server.verifyAuthToken(authRequest(host, token, nonce)); err == nil {
+		t.Error("replayed token accepted")
+	}
+	fresh := client.generateAuthToken(host, challenge, nonce)
+	if err := server.verifyAuthToken(authRequest(host, fresh, nonce)); err == nil {
+		t.Error("reused challenge accepted with a new token")
+	}
+}
@@ -0,0 +1,292 @@
+// 内部包，实现目标地址组负载均衡
+package internal
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 负载均衡策略
+const (
+	lbRoundRobin       = "rr"     // 轮询
+	lbWeightRoundRobin = "wrr"    // 加权轮询
+	lbLeastConn        = "lc"     // 最少连接
+	lbHash             = "hash"   // 来源IP一致性哈希
+	lbRandom           = "random" // 随机
+)
+
+// targetState 单个目标的运行状态
+type targetState struct {
+	weight     int    // 权重
+	active     int32  // 活跃连接数
+	failures   uint32 // 连续失败次数
+	ejectUntil int64  // 剔除截止时间
+	current    int    // 加权轮询当前值
+}
+
+// targetGroup 目标地址组
+type targetGroup struct {
+	rawAddrs []string       // 原始目标地址组
+	tcpAddrs []*net.TCPAddr // 目标TCP地址组
+	udpAddrs []*net.UDPAddr // 目标UDP地址组
+	states   []*targetState // 目标状态组
+	span     int            // 端口范围端口数
+	index    uint64         // 轮询索引
+	mu       sync.Mutex     // 加权轮询锁
+}
+
+// splitTargetWeight 拆分目标地址与权重，格式为addr*weight
+func splitTargetWeight(addr string) (string, int, error) {
+	idx := strings.LastIndex(addr, "*")
+	if idx < 0 {
+		return addr, 1, nil
+	}
+	weight, err := strconv.Atoi(addr[idx+1:])
+	if err != nil || weight <= 0 {
+		return "", 0, fmt.Errorf("splitTargetWeight: invalid weight for %s", addr)
+	}
+	return addr[:idx], weight, nil
+}
+
+// inherit 继承旧地址组中相同目标的运行状态
+func (g *targetGroup) inherit(old *targetGroup) {
+	if old == nil {
+		return
+	}
+	for i, addr := range g.rawAddrs {
+		for j, oldAddr := range old.rawAddrs {
+			if addr == oldAddr {
+				weight := g.states[i].weight
+				g.states[i] = old.states[j]
+				g.states[i].weight = weight
+				break
+			}
+		}
+	}
+}
+
+// String 返回目标地址组的字符串表示
+func (g *targetGroup) String() string {
+	if g == nil {
+		return ""
+	}
+	addrs := make([]string, len(g.tcpAddrs))
+	for i, addr := range g.tcpAddrs {
+		addrs[i] = addr.String()
+		if g.span > 1 {
+			addrs[i] += "-" + strconv.Itoa(addr.Port+g.span-1)
+		}
+		if weight := g.states[i].weight; weight > 1 {
+			addrs[i] += "*" + strconv.Itoa(weight)
+		}
+	}
+	return strings.Join(addrs, ",")
+}
+
+// healthy 判断目标是否可用
+func (g *targetGroup) healthy(i int, now int64) bool {
+	return atomic.LoadInt64(&g.states[i].ejectUntil) <= now
+}
+
+// markSuccess 标记目标连接成功
+func (g *targetGroup) markSuccess(i int) {
+	state := g.states[i]
+	if atomic.LoadUint32(&state.failures) > 0 {
+		atomic.StoreUint32(&state.failures, 0)
+		atomic.StoreInt64(&state.ejectUntil, 0)
+	}
+}
+
+// markFailure 标记目标连接失败并按指数退避剔除
+func (g *targetGroup) markFailure(i int) time.Duration {
+	state := g.states[i]
+	failures := atomic.AddUint32(&state.failures, 1)
+	backoff := targetEjectBase << min(failures-1, 16)
+	if backoff <= 0 || backoff > targetEjectMax {
+		backoff = targetEjectMax
+	}
+	atomic.StoreInt64(&state.ejectUntil, time.Now().Add(backoff).UnixNano())
+	return backoff
+}
+
+// order 按策略生成目标尝试顺序，可用目标优先，剔除目标兜底
+func (g *targetGroup) order(strategy, source string) []int {
+	now := time.Now().UnixNano()
+	healthy := make([]int, 0, len(g.rawAddrs))
+	ejected := make([]int, 0)
+	for i := range g.rawAddrs {
+		if g.healthy(i, now) {
+			healthy = append(healthy, i)
+		} else {
+			ejected = append(ejected, i)
+		}
+	}
+	if len(healthy) == 0 {
+		return ejected
+	}
+
+	// 来源IP亲和：按一致性哈希得分排序，故障转移顺序同样稳定
+	if strategy == lbHash && source != "" {
+		return append(g.rankByAffinity(healthy, sourceIP(source)), ejected...)
+	}
+
+	// 选择首选目标
+	pick := 0
+	switch strategy {
+	case lbWeightRoundRobin:
+		pick = g.pickWeighted(healthy)
+	case lbLeastConn:
+		pick = g.pickLeastConn(healthy)
+	case lbRandom:
+		pick = rand.IntN(len(healthy))
+	default:
+		pick = int((atomic.AddUint64(&g.index, 1) - 1) % uint64(len(healthy)))
+	}
+
+	// 首选目标之后依次故障转移
+	result := make([]int, 0, len(g.rawAddrs))
+	for i := range healthy {
+		result = append(result, healthy[(pick+i)%len(healthy)])
+	}
+	return append(result, ejected...)
+}
+
+// sourceIP 提取来源地址中的IP，使同一客户端的TCP与UDP落在同一目标
+func sourceIP(source string) string {
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		return host
+	}
+	return source
+}
+
+// rankByAffinity 按加权最高随机权重哈希对可用目标排序
+func (g *targetGroup) rankByAffinity(healthy []int, key string) []int {
+	scores := make(map[int]float64, len(healthy))
+	for _, idx := range healthy {
+		hash := fnv.New64a()
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(g.rawAddrs[idx]))
+		// 将哈希映射到(0,1)区间，得分 = -权重/ln(h)
+		h := (float64(hash.Sum64()>>11) + 0.5) / float64(1<<53)
+		scores[idx] = -float64(g.states[idx].weight) / math.Log(h)
+	}
+
+	ranked := slices.Clone(healthy)
+	slices.SortStableFunc(ranked, func(a, b int) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+	return ranked
+}
+
+// pickWeighted 平滑加权轮询选择
+func (g *targetGroup) pickWeighted(healthy []int) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	best, total := -1, 0
+	for i, idx := range healthy {
+		state := g.states[idx]
+		state.current += state.weight
+		total += state.weight
+		if best < 0 || state.current > g.states[healthy[best]].current {
+			best = i
+		}
+	}
+	g.states[healthy[best]].current -= total
+	return best
+}
+
+// pickLeastConn 按权重选择活跃连接最少的目标
+func (g *targetGroup) pickLeastConn(healthy []int) int {
+	best := 0
+	for i := 1; i < len(healthy); i++ {
+		current, chosen := g.states[healthy[i]], g.states[healthy[best]]
+		if int64(atomic.LoadInt32(&current.active))*int64(chosen.weight) <
+			int64(atomic.LoadInt32(&chosen.active))*int64(current.weight) {
+			best = i
+		}
+	}
+	return best
+}
+
+// probeTargets 主动探测目标组并更新健康状态，返回最低延迟
+func (c *Common) probeTargets(group *targetGroup) int {
+	if group == nil {
+		return 0
+	}
+
+	var wg sync.WaitGroup
+	latencies := make([]int64, len(group.rawAddrs))
+	for i := range group.rawAddrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			latencies[i] = -1
+			addr, _ := c.resolveTarget(group, "tcp", i)
+			tcpAddr, ok := addr.(*net.TCPAddr)
+			if !ok {
+				return
+			}
+			start := time.Now()
+			conn, err := net.DialTimeout("tcp", tcpAddr.String(), reportInterval)
+			if err != nil {
+				if atomic.LoadUint32(&group.states[i].failures) == 0 {
+					c.logger.Warn("probeTargets: target %v ejected: %v", group.rawAddrs[i], err)
+				}
+				group.markFailure(i)
+				return
+			}
+			latencies[i] = time.Since(start).Milliseconds()
+			conn.Close()
+			if atomic.LoadUint32(&group.states[i].failures) > 0 {
+				c.logger.Info("Target recovered: %v", group.rawAddrs[i])
+			}
+			group.markSuccess(i)
+		}(i)
+	}
+	wg.Wait()
+
+	// 取可用目标的最低延迟
+	ping := int64(-1)
+	for _, latency := range latencies {
+		if latency >= 0 && (ping < 0 || latency < ping) {
+			ping = latency
+		}
+	}
+	return int(max(ping, 0))
+}
+
+// targetProbeLoop 拨号端目标健康检查循环
+func (c *Common) targetProbeLoop() error {
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for c.ctx.Err() == nil {
+		if group := c.targets.Load(); group != nil && len(group.rawAddrs) > 1 {
+			c.probeTargets(group)
+		}
+		for _, route := range c.routes {
+			if group := route.targets.Load(); len(group.rawAddrs) > 1 {
+				c.probeTargets(group)
+			}
+		}
+		select {
+		case <-c.ctx.Done():
+			return fmt.Errorf("targetProbeLoop: context error: %w", c.ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return fmt.Errorf("targetProbeLoop: context error: %w", c.ctx.Err())
+}
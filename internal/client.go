@@ -15,7 +15,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/NodePassProject/logs"
 	"github.com/NodePassProject/nph2"
 	"github.com/NodePassProject/npws"
 	"github.com/NodePassProject/pool"
@@ -26,7 +25,7 @@ import (
 type Client struct{ Common }
 
 // NewClient 创建新的客户端实例
-func NewClient(parsedURL *url.URL, logger *logs.Logger) (*Client, error) {
+func NewClient(parsedURL *url.URL, logger Logger) (*Client, error) {
 	client := &Client{
 		Common: Common{
 			parsedURL:  parsedURL,
@@ -50,6 +49,9 @@ func NewClient(parsedURL *url.URL, logger *logs.Logger) (*Client, error) {
 	if err := client.initConfig(); err != nil {
 		return nil, fmt.Errorf("newClient: initConfig failed: %w", err)
 	}
+	if err := client.getClientTLS(); err != nil {
+		return nil, fmt.Errorf("newClient: %w", err)
+	}
 	client.initRateLimiter()
 	return client, nil
 }
@@ -57,12 +59,17 @@ func NewClient(parsedURL *url.URL, logger *logs.Logger) (*Client, error) {
 // Run 管理客户端生命周期
 func (c *Client) Run() {
 	logInfo := func(prefix string) {
-		c.logger.Info("%v: client://%v@%v/%v?dns=%v&sni=%v&min=%v&mode=%v&dial=%v&read=%v&rate=%v&slot=%v&proxy=%v&block=%v&notcp=%v&noudp=%v",
+		c.configMu.RLock()
+		defer c.configMu.RUnlock()
+		c.logger.Info("%v: client://%v@%v/%v?dns=%v&sni=%v&min=%v&mode=%v&dial=%v&read=%v&rate=%v&slot=%v&proxy=%v&block=%v&notcp=%v&noudp=%v&lb=%v",
 			prefix, c.tunnelKey, c.tunnelTCPAddr, c.getTargetAddrsString(), c.dnsCacheTTL, c.serverName, c.minPoolCapacity,
 			c.runMode, c.dialerIP, c.readTimeout, c.rateLimit/125000, c.slotLimit,
-			c.proxyProtocol, c.blockProtocol, c.disableTCP, c.disableUDP)
+			c.proxyProtocol, c.blockProtocol, c.disableTCP, c.disableUDP, c.lbStrategy)
 	}
 	logInfo("Client started")
+	c.logRoutes()
+	c.reportEvent(ipcEventStart)
+	go c.ipcCommandLoop()
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
@@ -72,6 +79,7 @@ func (c *Client) Run() {
 			// 启动客户端
 			if err := c.start(); err != nil && err != io.EOF {
 				c.logger.Error("Client error: %v", err)
+				c.reportError(err)
 				// 重启客户端
 				c.stop()
 				select {
@@ -80,6 +88,7 @@ func (c *Client) Run() {
 				case <-time.After(serviceCooldown):
 				}
 				logInfo("Client restart")
+				c.reportEvent(ipcEventRestart)
 			}
 		}
 	}()
@@ -87,6 +96,7 @@ func (c *Client) Run() {
 	// 监听系统信号以优雅关闭
 	<-ctx.Done()
 	stop()
+	c.reportEvent(ipcEventShutdown)
 
 	// 执行关闭过程
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
@@ -109,7 +119,7 @@ func (c *Client) start() error {
 		if err := c.initTunnelListener(); err == nil {
 			return c.singleStart()
 		} else {
-			return fmt.Errorf("start: initTunnelListener failed: %w", err)
+			return withCode(errCodeListen, fmt.Errorf("start: initTunnelListener failed: %w", err))
 		}
 	case "2": // 双端模式
 		return c.commonStart()
@@ -117,6 +127,9 @@ func (c *Client) start() error {
 		if err := c.initTunnelListener(); err == nil {
 			c.runMode = "1"
 			return c.singleStart()
+		} else if c.tunnelSpan > 1 {
+			// 端口范围仅用于单端模式，监听失败时不回退双端模式
+			return withCode(errCodeListen, fmt.Errorf("start: initTunnelListener failed: %w", err))
 		} else {
 			c.runMode = "2"
 			return c.commonStart()
@@ -126,8 +139,21 @@ func (c *Client) start() error {
 
 // singleStart 启动单端转发模式
 func (c *Client) singleStart() error {
+	// 目标端口范围须与监听端口范围大小一致
+	if group := c.targets.Load(); group != nil && group.span > 1 && group.span != c.tunnelSpan {
+		return fmt.Errorf("singleStart: target range size %d requires listen range of the same size", group.span)
+	}
+
+	// 监听隧道地址端口范围内的其余端口
+	if c.tunnelSpan > 1 {
+		if err := c.initPortRange(c.tunnelTCPAddr, c.tunnelUDPAddr, c.tunnelSpan, ""); err != nil {
+			c.closePortRange()
+			return withCode(errCodeListen, fmt.Errorf("singleStart: initPortRange failed: %w", err))
+		}
+	}
+
 	if err := c.singleControl(); err != nil {
-		return fmt.Errorf("singleStart: singleControl failed: %w", err)
+		return withCode(errCodeControl, fmt.Errorf("singleStart: singleControl failed: %w", err))
 	}
 	return nil
 }
@@ -138,31 +164,31 @@ func (c *Client) commonStart() error {
 	c.logger.Info("Pending tunnel handshake...")
 	c.handshakeStart = time.Now()
 	if err := c.tunnelHandshake(); err != nil {
-		return fmt.Errorf("commonStart: tunnelHandshake failed: %w", err)
+		return withCode(errCodeHandshake, fmt.Errorf("commonStart: tunnelHandshake failed: %w", err))
 	}
 
 	// 初始化连接池
 	if err := c.initTunnelPool(); err != nil {
-		return fmt.Errorf("commonStart: initTunnelPool failed: %w", err)
+		return withCode(errCodePool, fmt.Errorf("commonStart: initTunnelPool failed: %w", err))
 	}
 
 	// 设置控制连接
 	c.logger.Info("Getting tunnel pool ready...")
 	if err := c.setControlConn(); err != nil {
-		return fmt.Errorf("commonStart: setControlConn failed: %w", err)
+		return withCode(errCodePool, fmt.Errorf("commonStart: setControlConn failed: %w", err))
 	}
 
 	// 判断数据流向
 	if c.dataFlow == "+" {
 		if err := c.initTargetListener(); err != nil {
-			return fmt.Errorf("commonStart: initTargetListener failed: %w", err)
+			return withCode(errCodeListen, fmt.Errorf("commonStart: initTargetListener failed: %w", err))
 		}
 		go c.commonLoop()
 	}
 
 	// 启动共用控制
 	if err := c.commonControl(); err != nil {
-		return fmt.Errorf("commonStart: commonControl failed: %w", err)
+		return withCode(errCodeControl, fmt.Errorf("commonStart: commonControl failed: %w", err))
 	}
 
 	return nil
@@ -172,6 +198,24 @@ func (c *Client) commonStart() error {
 func (c *Client) initTunnelPool() error {
 	switch c.poolType {
 	case "0":
+		// 自行握手时由拨号函数直接返回TLS连接
+		if c.selfTLS() {
+			tlsPool := newTLSPool(
+				c.minPoolCapacity,
+				c.maxPoolCapacity,
+				minPoolInterval,
+				maxPoolInterval,
+				func() (net.Conn, error) {
+					tcpAddr, err := c.getTunnelTCPAddr()
+					if err != nil {
+						return nil, err
+					}
+					return c.dialTunnelTLS(tcpAddr.String())
+				})
+			go tlsPool.ClientManager()
+			c.tunnelPool = tlsPool
+			break
+		}
 		tcpPool := pool.NewClientPool(
 			c.minPoolCapacity,
 			c.maxPoolCapacity,
@@ -249,14 +293,35 @@ func (c *Client) tunnelHandshake() error {
 		scheme = "https"
 	}
 
-	// 构建请求
+	// 生成控制信道握手随机数
+	clientNonce, err := newControlNonce()
+	if err != nil {
+		return fmt.Errorf("tunnelHandshake: %w", err)
+	}
+
+	// 获取服务端挑战
+	client := &http.Client{}
 	req, _ := http.NewRequest(http.MethodGet, scheme+"://"+c.tunnelAddr+"/", nil)
 	req.Host = c.serverName
-	req.Header.Set("Authorization", "Bearer "+c.generateAuthToken())
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("tunnelHandshake: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	challenge := resp.Header.Get(authChallengeHeader)
+	if resp.StatusCode != http.StatusUnauthorized || challenge == "" {
+		return fmt.Errorf("tunnelHandshake: challenge status %d", resp.StatusCode)
+	}
+
+	// 构建请求
+	req, _ = http.NewRequest(http.MethodGet, scheme+"://"+c.tunnelAddr+"/", nil)
+	req.Host = c.serverName
+	req.Header.Set("Authorization", c.generateAuthToken(req.Host, challenge, clientNonce))
+	req.Header.Set(controlNonceHeader, clientNonce)
 
 	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err = client.Do(req)
 	if err != nil {
 		return fmt.Errorf("tunnelHandshake: %w", err)
 	}
@@ -268,15 +333,21 @@ func (c *Client) tunnelHandshake() error {
 
 	// 解析配置
 	var config struct {
-		Flow string `json:"flow"`
-		Max  int    `json:"max"`
-		TLS  string `json:"tls"`
-		Type string `json:"type"`
+		Flow  string `json:"flow"`
+		Max   int    `json:"max"`
+		TLS   string `json:"tls"`
+		Type  string `json:"type"`
+		Nonce string `json:"nonce"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
 		return fmt.Errorf("tunnelHandshake: %w", err)
 	}
 
+	// 建立控制信道会话密钥
+	if err := c.initControlCipher(clientNonce, config.Nonce); err != nil {
+		return fmt.Errorf("tunnelHandshake: %w", err)
+	}
+
 	// 更新配置
 	c.dataFlow = config.Flow
 	c.maxPoolCapacity = config.Max
@@ -286,6 +357,27 @@ func (c *Client) tunnelHandshake() error {
 		c.verifyChan = make(chan struct{})
 	}
 
+	// 双向TLS需要客户端证书
+	if c.tlsCode == "3" && c.clientTLS == nil {
+		return fmt.Errorf("tunnelHandshake: server requires client certificate, set crt and key")
+	}
+	if c.tlsCode == "3" && c.poolType != "0" {
+		return fmt.Errorf("tunnelHandshake: mutual TLS requires TCP pool")
+	}
+
+	// 校验服务端证书
+	if c.verifyServer && c.tlsCode == "0" {
+		return fmt.Errorf("tunnelHandshake: server does not use TLS, certificate cannot be verified")
+	}
+	if c.verifyServer && c.poolType != "0" {
+		return fmt.Errorf("tunnelHandshake: certificate verification requires TCP pool (type=0)")
+	}
+	if c.selfTLS() {
+		if err := c.probeTunnelCert(); err != nil {
+			return fmt.Errorf("tunnelHandshake: %w", err)
+		}
+	}
+
 	c.logger.Info("Loading tunnel config: FLOW=%v|MAX=%v|TLS=%v|TYPE=%v",
 		c.dataFlow, c.maxPoolCapacity, c.tlsCode, c.poolType)
 	return nil
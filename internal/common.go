@@ -3,20 +3,20 @@ package internal
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"crypto/hmac"
 	"crypto/sha256"
 	"crypto/tls"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
 	"io"
+	"log"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -29,65 +29,84 @@ import (
 
 // Common 包含所有模式共享的核心功能
 type Common struct {
-	parsedURL        *url.URL           // 解析后的URL
-	logger           *logs.Logger       // 日志记录器
-	dnsCacheTTL      time.Duration      // DNS缓存TTL
-	dnsCacheEntries  sync.Map           // DNS缓存条目
-	tlsCode          string             // TLS模式代码
-	tlsConfig        *tls.Config        // TLS配置
-	coreType         string             // 核心类型
-	runMode          string             // 运行模式
-	poolType         string             // 连接池类型
-	dataFlow         string             // 数据流向
-	serverName       string             // 服务器名称
-	serverPort       string             // 服务器端口
-	clientIP         string             // 客户端地址
-	dialerIP         string             // 拨号本地IP
-	dialerFallback   uint32             // 拨号回落标志
-	tunnelKey        string             // 隧道密钥
-	tunnelAddr       string             // 原始隧道地址
-	tunnelTCPAddr    *net.TCPAddr       // 隧道TCP地址
-	tunnelUDPAddr    *net.UDPAddr       // 隧道UDP地址
-	targetAddrs      []string           // 原始目标地址组
-	targetTCPAddrs   []*net.TCPAddr     // 目标TCP地址组
-	targetUDPAddrs   []*net.UDPAddr     // 目标UDP地址组
-	targetIdx        uint64             // 目标地址索引
-	targetListener   *net.TCPListener   // 目标监听器
-	tunnelListener   net.Listener       // 隧道监听器
-	controlConn      net.Conn           // 隧道控制连接
-	tunnelUDPConn    *conn.StatConn     // 隧道UDP连接
-	targetUDPConn    *conn.StatConn     // 目标UDP连接
-	targetUDPSession sync.Map           // 目标UDP会话
-	tunnelPool       TransportPool      // 隧道连接池
-	minPoolCapacity  int                // 最小池容量
-	maxPoolCapacity  int                // 最大池容量
-	proxyProtocol    string             // 代理协议
-	blockProtocol    string             // 屏蔽协议
-	blockSOCKS       bool               // 屏蔽SOCKS协议
-	blockHTTP        bool               // 屏蔽HTTP协议
-	blockTLS         bool               // 屏蔽TLS协议
-	disableTCP       string             // 禁用TCP
-	disableUDP       string             // 禁用UDP
-	rateLimit        int                // 速率限制
-	rateLimiter      *conn.RateLimiter  // 全局限速器
-	readTimeout      time.Duration      // 读取超时
-	bufReader        *bufio.Reader      // 缓冲读取器
-	tcpBufferPool    *sync.Pool         // TCP缓冲区池
-	udpBufferPool    *sync.Pool         // UDP缓冲区池
-	signalChan       chan Signal        // 信号通道
-	writeChan        chan []byte        // 写入通道
-	verifyChan       chan struct{}      // 证书验证通道
-	handshakeStart   time.Time          // 握手开始时间
-	checkPoint       time.Time          // 检查点时间
-	slotLimit        int32              // 槽位限制
-	tcpSlot          int32              // TCP连接数
-	udpSlot          int32              // UDP连接数
-	tcpRX            uint64             // TCP接收字节数
-	tcpTX            uint64             // TCP发送字节数
-	udpRX            uint64             // UDP接收字节数
-	udpTX            uint64             // UDP发送字节数
-	ctx              context.Context    // 上下文
-	cancel           context.CancelFunc // 取消函数
+	parsedURL        *url.URL                         // 解析后的URL
+	logger           Logger                           // 日志记录器
+	dnsCacheTTL      time.Duration                    // DNS缓存TTL
+	dnsCacheEntries  sync.Map                         // DNS缓存条目
+	tlsCode          string                           // TLS模式代码
+	tlsConfig        *tls.Config                      // TLS配置
+	clientTLS        *tls.Config                      // 客户端TLS配置
+	verifyServer     bool                             // 客户端校验服务端证书
+	tunnelFP         atomic.Value                     // 自行握手的服务端证书指纹
+	coreType         string                           // 核心类型
+	runMode          string                           // 运行模式
+	poolType         string                           // 连接池类型
+	dataFlow         string                           // 数据流向
+	serverName       string                           // 服务器名称
+	serverPort       string                           // 服务器端口
+	clientIP         string                           // 客户端地址
+	dialerIP         string                           // 拨号本地IP
+	dialerFallback   uint32                           // 拨号回落标志
+	tunnelKey        string                           // 隧道密钥
+	tunnelAddr       string                           // 原始隧道地址
+	tunnelSpan       int                              // 隧道地址端口范围端口数
+	tunnelTCPAddr    *net.TCPAddr                     // 隧道TCP地址
+	tunnelUDPAddr    *net.UDPAddr                     // 隧道UDP地址
+	targets          atomic.Pointer[targetGroup]      // 目标地址组
+	routes           []*routeMapping                  // 命名目标映射
+	rangePorts       []*rangePort                     // 端口范围附加监听
+	lbStrategy       string                           // 负载均衡策略
+	targetListener   *net.TCPListener                 // 目标监听器
+	tunnelListener   net.Listener                     // 隧道监听器
+	controlConn      net.Conn                         // 隧道控制连接
+	tunnelUDPConn    *conn.StatConn                   // 隧道UDP连接
+	targetUDPConn    *conn.StatConn                   // 目标UDP连接
+	transparentUDP   *net.UDPConn                     // 透明代理UDP连接
+	targetUDPSession sync.Map                         // 目标UDP会话
+	tunnelPool       TransportPool                    // 隧道连接池
+	minPoolCapacity  int                              // 最小池容量
+	maxPoolCapacity  int                              // 最大池容量
+	proxyProtocol    string                           // 代理协议
+	ingressMode      string                           // 入口模式
+	destAllow        atomic.Pointer[destAllowlist]    // 动态目标放行列表
+	dialResults      sync.Map                         // 动态目标拨号结果等待表
+	vhosts           atomic.Pointer[[]virtualHost]    // 虚拟主机路由
+	trustedProxies   []netip.Prefix                   // 可信代理来源
+	access           atomic.Pointer[accessControl]    // 来源访问控制
+	sources          sourceTable                      // 来源连接状态
+	shaping          atomic.Pointer[shapingConfig]    // 带宽整形配置
+	blockProtocol    string                           // 屏蔽协议
+	blockSOCKS       bool                             // 屏蔽SOCKS协议
+	blockHTTP        bool                             // 屏蔽HTTP协议
+	blockTLS         bool                             // 屏蔽TLS协议
+	disableTCP       string                           // 禁用TCP
+	disableUDP       string                           // 禁用UDP
+	rateLimit        int                              // 速率限制
+	rateLimiter      atomic.Pointer[conn.RateLimiter] // 全局限速器
+	readTimeout      time.Duration                    // 读取超时
+	configMu         sync.RWMutex                     // 可变配置读写锁，保护URL与热重载字段
+	bufReader        *bufio.Reader                    // 缓冲读取器
+	tcpBufferPool    *sync.Pool                       // TCP缓冲区池
+	udpBufferPool    *sync.Pool                       // UDP缓冲区池
+	signalChan       chan Signal                      // 信号通道
+	writeChan        chan []byte                      // 写入通道
+	controlCipher    *controlCipher                   // 控制信道加密
+	verifyChan       chan struct{}                    // 证书验证通道
+	handshakeStart   time.Time                        // 握手开始时间
+	checkPoint       time.Time                        // 检查点时间
+	slotLimit        int32                            // 槽位限制
+	tcpSlot          int32                            // TCP连接数
+	udpSlot          int32                            // UDP连接数
+	tcpRX            uint64                           // TCP接收字节数
+	tcpTX            uint64                           // TCP发送字节数
+	udpRX            uint64                           // UDP接收字节数
+	udpTX            uint64                           // UDP发送字节数
+	rejects          uint64                           // 拒绝连接数
+	authRejects      uint64                           // 拒绝握手数
+	authChallenges   sync.Map                         // 已使用的握手挑战
+	authHost         string                           // 握手认证绑定的服务端名称
+	ctx              context.Context                  // 上下文
+	cancel           context.CancelFunc               // 取消函数
 }
 
 // dnsCacheEntry DNS缓存条目
@@ -123,12 +142,54 @@ type TransportPool interface {
 	ResetError()
 }
 
+// Logger 统一日志接口
+type Logger interface {
+	Debug(format string, v ...any)
+	Info(format string, v ...any)
+	Warn(format string, v ...any)
+	Error(format string, v ...any)
+	Event(format string, v ...any)
+	StdLogger() *log.Logger
+}
+
+// prefixLogger 为每条日志添加固定前缀
+type prefixLogger struct {
+	*logs.Logger
+	prefix string // 日志前缀
+}
+
+// NewPrefixLogger 创建带前缀的日志记录器
+func NewPrefixLogger(logger *logs.Logger, prefix string) Logger {
+	return &prefixLogger{Logger: logger, prefix: prefix + " "}
+}
+
+// Debug 输出带前缀的调试日志
+func (l *prefixLogger) Debug(format string, v ...any) { l.Logger.Debug(l.prefix+format, v...) }
+
+// Info 输出带前缀的信息日志
+func (l *prefixLogger) Info(format string, v ...any) { l.Logger.Info(l.prefix+format, v...) }
+
+// Warn 输出带前缀的警告日志
+func (l *prefixLogger) Warn(format string, v ...any) { l.Logger.Warn(l.prefix+format, v...) }
+
+// Error 输出带前缀的错误日志
+func (l *prefixLogger) Error(format string, v ...any) { l.Logger.Error(l.prefix+format, v...) }
+
+// Event 输出带前缀的事件日志
+func (l *prefixLogger) Event(format string, v ...any) { l.Logger.Event(l.prefix+format, v...) }
+
 // Signal 操作信号结构体
 type Signal struct {
 	ActionType  string `json:"action"`           // 操作类型
 	RemoteAddr  string `json:"remote,omitempty"` // 远程地址
 	PoolConnID  string `json:"id,omitempty"`     // 池连接ID
 	Fingerprint string `json:"fp,omitempty"`     // TLS指纹
+	ServerName  string `json:"sni,omitempty"`    // 来源TLS服务器名称
+	Route       string `json:"route,omitempty"`  // 目标映射名称
+	PortOffset  int    `json:"offset,omitempty"` // 端口范围偏移
+	Target      string `json:"target,omitempty"` // 动态目标地址
+	Reply       bool   `json:"reply,omitempty"`  // 是否回报拨号结果
+	Result      string `json:"result,omitempty"` // 动态目标拨号结果
 }
 
 // 配置变量，可通过环境变量调整
@@ -145,8 +206,12 @@ var (
 	maxPoolInterval  = getEnvAsDuration("NP_MAX_POOL_INTERVAL", 1*time.Second)        // 最大池间隔
 	reportInterval   = getEnvAsDuration("NP_REPORT_INTERVAL", 5*time.Second)          // 报告间隔
 	serviceCooldown  = getEnvAsDuration("NP_SERVICE_COOLDOWN", 3*time.Second)         // 服务冷却时间
+	sniPeekTimeout   = getEnvAsDuration("NP_SNI_PEEK_TIMEOUT", 250*time.Millisecond)  // SNI预读超时
 	shutdownTimeout  = getEnvAsDuration("NP_SHUTDOWN_TIMEOUT", 5*time.Second)         // 关闭超时
 	ReloadInterval   = getEnvAsDuration("NP_RELOAD_INTERVAL", 1*time.Hour)            // 重载间隔
+	targetEjectBase  = getEnvAsDuration("NP_TARGET_EJECT_BASE", 1*time.Second)        // 目标剔除基础时长
+	targetEjectMax   = getEnvAsDuration("NP_TARGET_EJECT_MAX", 1*time.Minute)         // 目标剔除最大时长
+	authSkew         = getEnvAsDuration("NP_AUTH_SKEW", 30*time.Second)               // 握手认证时钟偏差容忍
 )
 
 // 常量定义
@@ -161,11 +226,13 @@ const (
 	defaultDialerIP      = "auto"                // 默认拨号本地IP
 	defaultReadTimeout   = 0 * time.Second       // 默认读取超时
 	defaultRateLimit     = 0                     // 默认速率限制
+	unlimitedRate        = 1 << 40               // 不限速时的速率上限
 	defaultSlotLimit     = 65536                 // 默认槽位限制
 	defaultProxyProtocol = "0"                   // 默认代理协议
 	defaultBlockProtocol = "0"                   // 默认协议屏蔽
 	defaultTCPStrategy   = "0"                   // 默认TCP策略
 	defaultUDPStrategy   = "0"                   // 默认UDP策略
+	defaultLBStrategy    = "rr"                  // 默认负载均衡策略
 )
 
 // getTCPBuffer 获取TCP缓冲区
@@ -196,12 +263,10 @@ func (c *Common) putUDPBuffer(buf []byte) {
 
 // tryAcquireSlot 尝试获取一个连接槽位
 func (c *Common) tryAcquireSlot(isUDP bool) bool {
-	if c.slotLimit == 0 {
-		return true
-	}
-
+	// 槽位限制为0时不限制，但仍计数以便热重载后保持一致
+	slotLimit := atomic.LoadInt32(&c.slotLimit)
 	currentTotal := atomic.LoadInt32(&c.tcpSlot) + atomic.LoadInt32(&c.udpSlot)
-	if currentTotal >= c.slotLimit {
+	if slotLimit > 0 && currentTotal >= slotLimit {
 		return false
 	}
 
@@ -215,10 +280,6 @@ func (c *Common) tryAcquireSlot(isUDP bool) bool {
 
 // releaseSlot 释放一个连接槽位
 func (c *Common) releaseSlot(isUDP bool) {
-	if c.slotLimit == 0 {
-		return
-	}
-
 	if isUDP {
 		if current := atomic.LoadInt32(&c.udpSlot); current > 0 {
 			atomic.AddInt32(&c.udpSlot, -1)
@@ -240,6 +301,14 @@ func getEnvAsInt(name string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsString 从环境变量获取字符串，如果不存在则使用默认值
+func getEnvAsString(name string, defaultValue string) string {
+	if value, exists := os.LookupEnv(name); exists {
+		return value
+	}
+	return defaultValue
+}
+
 // getEnvAsDuration 从环境变量获取时间间隔，如果不存在则使用默认值
 func getEnvAsDuration(name string, defaultValue time.Duration) time.Duration {
 	if valueStr, exists := os.LookupEnv(name); exists {
@@ -266,38 +335,6 @@ func (c *Common) formatCertFingerprint(certRaw []byte) string {
 	return "sha256:" + formatted.String()
 }
 
-// xor 对数据进行异或处理
-func (c *Common) xor(data []byte) []byte {
-	for i := range data {
-		data[i] ^= c.tunnelKey[i%len(c.tunnelKey)]
-	}
-	return data
-}
-
-// generateAuthToken 生成认证令牌
-func (c *Common) generateAuthToken() string {
-	return hex.EncodeToString(hmac.New(sha256.New, []byte(c.tunnelKey)).Sum(nil))
-}
-
-// verifyAuthToken 验证认证令牌
-func (c *Common) verifyAuthToken(token string) bool {
-	return hmac.Equal([]byte(token), []byte(c.generateAuthToken()))
-}
-
-// encode base64编码数据
-func (c *Common) encode(data []byte) []byte {
-	return append([]byte(base64.StdEncoding.EncodeToString(c.xor(data))), '\n')
-}
-
-// decode base64解码数据
-func (c *Common) decode(data []byte) ([]byte, error) {
-	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSuffix(data, []byte{'\n'})))
-	if err != nil {
-		return nil, fmt.Errorf("decode: base64 decode failed: %w", err)
-	}
-	return c.xor(decoded), nil
-}
-
 // resolve 解析地址并缓存
 func (c *Common) resolve(network, address string) (any, error) {
 	now := time.Now()
@@ -366,17 +403,17 @@ func (c *Common) resolveAddr(network, address string) (any, error) {
 }
 
 // resolveTarget 动态解析目标地址
-func (c *Common) resolveTarget(network string, idx int) (any, error) {
-	if idx < 0 || idx >= len(c.targetAddrs) {
+func (c *Common) resolveTarget(group *targetGroup, network string, idx int) (any, error) {
+	if group == nil || idx < 0 || idx >= len(group.rawAddrs) {
 		return nil, fmt.Errorf("resolveTarget: index %d out of range", idx)
 	}
 
-	addr, err := c.resolveAddr(network, c.targetAddrs[idx])
+	addr, err := c.resolveAddr(network, group.rawAddrs[idx])
 	if err != nil {
 		if network == "tcp" {
-			return c.targetTCPAddrs[idx], err
+			return group.tcpAddrs[idx], err
 		}
-		return c.targetUDPAddrs[idx], err
+		return group.udpAddrs[idx], err
 	}
 	return addr, nil
 }
@@ -401,34 +438,29 @@ func (c *Common) getTunnelUDPAddr() (*net.UDPAddr, error) {
 
 // getTargetAddrsString 获取目标地址组的字符串表示
 func (c *Common) getTargetAddrsString() string {
-	addrs := make([]string, len(c.targetTCPAddrs))
-	for i, addr := range c.targetTCPAddrs {
-		addrs[i] = addr.String()
-	}
-	return strings.Join(addrs, ",")
+	return c.targets.Load().String()
 }
 
-// nextTargetIdx 获取下一个目标地址索引
-func (c *Common) nextTargetIdx() int {
-	if len(c.targetTCPAddrs) <= 1 {
-		return 0
+// dialWithRotation 按负载均衡策略拨号到目标地址组，返回的释放函数需在连接结束时调用
+func (c *Common) dialWithRotation(group *targetGroup, network, source string, offset int, timeout time.Duration) (net.Conn, func(), error) {
+	if group == nil {
+		return nil, nil, fmt.Errorf("dialWithRotation: no target address")
+	}
+
+	// 端口范围偏移校验，单端口目标接收整个范围
+	if group.span <= 1 {
+		offset = 0
+	} else if offset < 0 || offset >= group.span {
+		return nil, nil, fmt.Errorf("dialWithRotation: port offset %d out of range %d", offset, group.span)
 	}
-	return int((atomic.AddUint64(&c.targetIdx, 1) - 1) % uint64(len(c.targetTCPAddrs)))
-}
 
-// dialWithRotation 轮询拨号到目标地址组
-func (c *Common) dialWithRotation(network string, timeout time.Duration) (net.Conn, error) {
-	addrCount := len(c.targetAddrs)
+	c.configMu.RLock()
+	strategy := c.lbStrategy
+	c.configMu.RUnlock()
 
 	getAddr := func(i int) string {
-		addr, _ := c.resolveTarget(network, i)
-		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
-			return tcpAddr.String()
-		}
-		if udpAddr, ok := addr.(*net.UDPAddr); ok {
-			return udpAddr.String()
-		}
-		return ""
+		addr, _ := c.resolveTarget(group, network, i)
+		return offsetAddr(addr, offset)
 	}
 
 	// 配置拨号器
@@ -453,29 +485,38 @@ func (c *Common) dialWithRotation(network string, timeout time.Duration) (net.Co
 	}
 
 	// 单目标地址：快速路径
-	if addrCount == 1 {
+	if len(group.rawAddrs) == 1 {
 		if addr := getAddr(0); addr != "" {
-			return tryDial(addr)
+			conn, err := tryDial(addr)
+			return conn, func() {}, err
 		}
-		return nil, fmt.Errorf("dialWithRotation: invalid target address")
+		return nil, nil, fmt.Errorf("dialWithRotation: invalid target address")
 	}
 
 	// 多目标地址：负载均衡 + 故障转移
-	startIdx := c.nextTargetIdx()
 	var lastErr error
-	for i := range addrCount {
-		addr := getAddr((startIdx + i) % addrCount)
+	for _, idx := range group.order(strategy, source) {
+		addr := getAddr(idx)
 		if addr == "" {
 			continue
 		}
 		conn, err := tryDial(addr)
-		if err == nil {
-			return conn, nil
+		if err != nil {
+			lastErr = err
+			backoff := group.markFailure(idx)
+			c.logger.Warn("dialWithRotation: target %v ejected for %v: %v", group.rawAddrs[idx], backoff, err)
+			continue
 		}
-		lastErr = err
+
+		// 记录活跃连接
+		group.markSuccess(idx)
+		state := group.states[idx]
+		atomic.AddInt32(&state.active, 1)
+		var once sync.Once
+		return conn, func() { once.Do(func() { atomic.AddInt32(&state.active, -1) }) }, nil
 	}
 
-	return nil, fmt.Errorf("dialWithRotation: all %d targets failed: %w", addrCount, lastErr)
+	return nil, nil, fmt.Errorf("dialWithRotation: all %d targets failed: %w", len(group.rawAddrs), lastErr)
 }
 
 // getAddress 解析和设置地址信息
@@ -486,6 +527,13 @@ func (c *Common) getAddress() error {
 		return fmt.Errorf("getAddress: no valid tunnel address found")
 	}
 
+	// 解析隧道地址端口范围，仅用于单端模式监听
+	tunnelAddr, tunnelSpan, err := splitPortRange(tunnelAddr)
+	if err != nil {
+		return fmt.Errorf("getAddress: %w", err)
+	}
+	c.tunnelSpan = tunnelSpan
+
 	// 保存原始隧道地址
 	c.tunnelAddr = tunnelAddr
 	if name, port, err := net.SplitHostPort(tunnelAddr); err == nil {
@@ -512,10 +560,25 @@ func (c *Common) getAddress() error {
 		return fmt.Errorf("getAddress: no valid target address found")
 	}
 
+	group, err := c.parseTargetAddrs(targetAddr)
+	if err != nil {
+		return fmt.Errorf("getAddress: %w", err)
+	}
+
+	// 设置目标地址组
+	c.targets.Store(group)
+
+	return nil
+}
+
+// parseTargetAddrs 解析目标地址组
+func (c *Common) parseTargetAddrs(targetAddr string) (*targetGroup, error) {
 	addrList := strings.Split(targetAddr, ",")
 	tempTCPAddrs := make([]*net.TCPAddr, 0, len(addrList))
 	tempUDPAddrs := make([]*net.UDPAddr, 0, len(addrList))
 	tempRawAddrs := make([]string, 0, len(addrList))
+	tempStates := make([]*targetState, 0, len(addrList))
+	span := 0
 
 	for _, addr := range addrList {
 		addr = strings.TrimSpace(addr)
@@ -523,42 +586,64 @@ func (c *Common) getAddress() error {
 			continue
 		}
 
+		// 解析目标权重
+		addr, weight, err := splitTargetWeight(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parseTargetAddrs: %w", err)
+		}
+
+		// 解析端口范围
+		addr, addrSpan, err := splitPortRange(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parseTargetAddrs: %w", err)
+		}
+		if span != 0 && addrSpan != span {
+			return nil, fmt.Errorf("parseTargetAddrs: port range size mismatch for %s", addr)
+		}
+		span = addrSpan
+
 		// 解析目标TCP地址
 		tcpAddr, err := c.resolveAddr("tcp", addr)
 		if err != nil {
-			return fmt.Errorf("getAddress: resolveTCPAddr failed for %s: %w", addr, err)
+			return nil, fmt.Errorf("parseTargetAddrs: resolveTCPAddr failed for %s: %w", addr, err)
 		}
 
 		// 解析目标UDP地址
 		udpAddr, err := c.resolveAddr("udp", addr)
 		if err != nil {
-			return fmt.Errorf("getAddress: resolveUDPAddr failed for %s: %w", addr, err)
+			return nil, fmt.Errorf("parseTargetAddrs: resolveUDPAddr failed for %s: %w", addr, err)
 		}
 
 		tempTCPAddrs = append(tempTCPAddrs, tcpAddr.(*net.TCPAddr))
 		tempUDPAddrs = append(tempUDPAddrs, udpAddr.(*net.UDPAddr))
 		tempRawAddrs = append(tempRawAddrs, addr)
+		tempStates = append(tempStates, &targetState{weight: weight})
 	}
 
 	if len(tempTCPAddrs) == 0 || len(tempUDPAddrs) == 0 || len(tempTCPAddrs) != len(tempUDPAddrs) {
-		return fmt.Errorf("getAddress: no valid target address found")
+		return nil, fmt.Errorf("parseTargetAddrs: no valid target address found")
 	}
 
-	// 设置目标地址组
-	c.targetAddrs = tempRawAddrs
-	c.targetTCPAddrs = tempTCPAddrs
-	c.targetUDPAddrs = tempUDPAddrs
-	c.targetIdx = 0
+	// 单端模式监听范围须与目标范围大小一致，单端口目标接收整个范围
+	if c.tunnelSpan > 1 && span > 1 && span != c.tunnelSpan {
+		return nil, fmt.Errorf("parseTargetAddrs: target range size %d does not match listen range size %d", span, c.tunnelSpan)
+	}
 
 	// 无限循环检查
 	tunnelPort := c.tunnelTCPAddr.Port
-	for _, targetAddr := range c.targetTCPAddrs {
-		if targetAddr.Port == tunnelPort && (targetAddr.IP.IsLoopback() || c.tunnelTCPAddr.IP.IsUnspecified()) {
-			return fmt.Errorf("getAddress: tunnel port %d conflicts with target address %s", tunnelPort, targetAddr.String())
+	for _, targetAddr := range tempTCPAddrs {
+		if tunnelPort < targetAddr.Port+span && targetAddr.Port < tunnelPort+c.tunnelSpan && (targetAddr.IP.IsLoopback() || c.tunnelTCPAddr.IP.IsUnspecified()) {
+			return nil, fmt.Errorf("parseTargetAddrs: tunnel port %d conflicts with target address %s", tunnelPort, targetAddr.String())
 		}
 	}
 
-	return nil
+	return &targetGroup{
+		rawAddrs: tempRawAddrs,
+		tcpAddrs: tempTCPAddrs,
+		udpAddrs: tempUDPAddrs,
+		states:   tempStates,
+		span:     span,
+	}, nil
 }
 
 // getCoreType 获取核心类型
@@ -591,7 +676,7 @@ func (c *Common) getDNSTTL() {
 // getServerName 获取服务器名称
 func (c *Common) getServerName() {
 	if serverName := c.parsedURL.Query().Get("sni"); serverName != "" {
-		c.serverName = serverName
+		c.serverName, c.authHost = serverName, serverName
 		return
 	}
 	if c.serverName == "" || net.ParseIP(c.serverName) != nil {
@@ -655,7 +740,7 @@ func (c *Common) getDialerIP() {
 // getReadTimeout 获取读取超时设置
 func (c *Common) getReadTimeout() {
 	if timeout := c.parsedURL.Query().Get("read"); timeout != "" {
-		if value, err := time.ParseDuration(timeout); err == nil && value > 0 {
+		if value, err := time.ParseDuration(timeout); err == nil && value >= 0 {
 			c.readTimeout = value
 		}
 	} else {
@@ -666,7 +751,7 @@ func (c *Common) getReadTimeout() {
 // getRateLimit 获取速率限制
 func (c *Common) getRateLimit() {
 	if limit := c.parsedURL.Query().Get("rate"); limit != "" {
-		if value, err := strconv.Atoi(limit); err == nil && value > 0 {
+		if value, err := strconv.Atoi(limit); err == nil && value >= 0 {
 			c.rateLimit = value * 125000
 		}
 	} else {
@@ -677,11 +762,11 @@ func (c *Common) getRateLimit() {
 // getSlotLimit 获取连接槽位限制
 func (c *Common) getSlotLimit() {
 	if slot := c.parsedURL.Query().Get("slot"); slot != "" {
-		if value, err := strconv.Atoi(slot); err == nil && value > 0 {
-			c.slotLimit = int32(value)
+		if value, err := strconv.Atoi(slot); err == nil && value >= 0 {
+			atomic.StoreInt32(&c.slotLimit, int32(value))
 		}
 	} else {
-		c.slotLimit = defaultSlotLimit
+		atomic.StoreInt32(&c.slotLimit, defaultSlotLimit)
 	}
 }
 
@@ -724,11 +809,29 @@ func (c *Common) getUDPStrategy() {
 	}
 }
 
+// getLBStrategy 获取负载均衡策略
+func (c *Common) getLBStrategy() {
+	switch lb := c.parsedURL.Query().Get("lb"); lb {
+	case lbRoundRobin, lbWeightRoundRobin, lbLeastConn, lbHash, lbRandom:
+		c.lbStrategy = lb
+	default:
+		c.lbStrategy = defaultLBStrategy
+	}
+}
+
+// getTrustedProxies 获取可信PROXY协议来源
+func (c *Common) getTrustedProxies() {
+	c.trustedProxies = c.parsePrefixes("trust")
+}
+
 // initConfig 初始化配置
 func (c *Common) initConfig() error {
 	if err := c.getAddress(); err != nil {
 		return err
 	}
+	if err := c.getRouteMappings(); err != nil {
+		return err
+	}
 
 	c.getCoreType()
 	c.getDNSTTL()
@@ -736,59 +839,38 @@ func (c *Common) initConfig() error {
 	c.getPoolCapacity()
 	c.getServerName()
 	c.getRunMode()
+	if c.tunnelSpan > 1 && (c.coreType != "client" || c.runMode == "2") {
+		return fmt.Errorf("initConfig: tunnel port range requires client single-end mode")
+	}
 	c.getPoolType()
 	c.getDialerIP()
 	c.getReadTimeout()
 	c.getRateLimit()
 	c.getSlotLimit()
 	c.getProxyProtocol()
+	c.getTrustedProxies()
+	c.getAccessControl()
+	c.getBandwidthShaping()
 	c.getBlockProtocol()
 	c.getTCPStrategy()
 	c.getUDPStrategy()
-
-	return nil
-}
-
-// sendProxyV1Header 发送PROXY v1
-func (c *Common) sendProxyV1Header(ip string, conn net.Conn) error {
-	if c.proxyProtocol != "1" {
-		return nil
-	}
-
-	clientAddr, err := net.ResolveTCPAddr("tcp", ip)
-	if err != nil {
-		return fmt.Errorf("sendProxyV1Header: resolveTCPAddr failed: %w", err)
-	}
-	remoteAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
-	if !ok {
-		return fmt.Errorf("sendProxyV1Header: remote address is not TCPAddr")
-	}
-
-	var protocol string
-	switch {
-	case clientAddr.IP.To4() != nil && remoteAddr.IP.To4() != nil:
-		protocol = "TCP4"
-	case clientAddr.IP.To16() != nil && remoteAddr.IP.To16() != nil:
-		protocol = "TCP6"
-	default:
-		return fmt.Errorf("sendProxyV1Header: unsupported IP protocol for PROXY v1")
-	}
-
-	if _, err = fmt.Fprintf(conn, "PROXY %s %s %s %d %d\r\n",
-		protocol,
-		clientAddr.IP.String(),
-		remoteAddr.IP.String(),
-		clientAddr.Port,
-		remoteAddr.Port); err != nil {
-		return fmt.Errorf("sendProxyV1Header: fprintf failed: %w", err)
+	c.getLBStrategy()
+	if err := c.getIngressMode(); err != nil {
+		return err
 	}
+	c.getDestAllowlist()
+	c.getVirtualHosts()
 
 	return nil
 }
 
 // detectBlockProtocol 检测屏蔽协议
 func (c *Common) detectBlockProtocol(conn net.Conn) (string, net.Conn) {
-	if !c.blockSOCKS && !c.blockHTTP && !c.blockTLS {
+	c.configMu.RLock()
+	blockSOCKS, blockHTTP, blockTLS := c.blockSOCKS, c.blockHTTP, c.blockTLS
+	c.configMu.RUnlock()
+
+	if !blockSOCKS && !blockHTTP && !blockTLS {
 		return "", conn
 	}
 
@@ -799,7 +881,7 @@ func (c *Common) detectBlockProtocol(conn net.Conn) (string, net.Conn) {
 	}
 
 	// 检测SOCKS
-	if c.blockSOCKS && len(b) >= 2 {
+	if blockSOCKS && len(b) >= 2 {
 		if b[0] == 0x04 && (b[1] == 0x01 || b[1] == 0x02) {
 			return "SOCKS4", &readerConn{Conn: conn, reader: reader}
 		}
@@ -809,7 +891,7 @@ func (c *Common) detectBlockProtocol(conn net.Conn) (string, net.Conn) {
 	}
 
 	// 检测HTTP
-	if c.blockHTTP && len(b) >= 4 && b[0] >= 'A' && b[0] <= 'Z' {
+	if blockHTTP && len(b) >= 4 && b[0] >= 'A' && b[0] <= 'Z' {
 		for i, c := range b[1:] {
 			if c == ' ' {
 				return "HTTP", &readerConn{Conn: conn, reader: reader}
@@ -821,18 +903,37 @@ func (c *Common) detectBlockProtocol(conn net.Conn) (string, net.Conn) {
 	}
 
 	// 检测TLS
-	if c.blockTLS && b[0] == 0x16 {
+	if blockTLS && b[0] == 0x16 {
 		return "TLS", &readerConn{Conn: conn, reader: reader}
 	}
 
 	return "", &readerConn{Conn: conn, reader: reader}
 }
 
-// initRateLimiter 初始化全局限速器
+// loadReadTimeout 获取当前读取超时
+func (c *Common) loadReadTimeout() time.Duration {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.readTimeout
+}
+
+// initRateLimiter 初始化全局限速器，可热重载时预先创建，使限速调整作用于现有连接
 func (c *Common) initRateLimiter() {
-	if c.rateLimit > 0 {
-		c.rateLimiter = conn.NewRateLimiter(int64(c.rateLimit), int64(c.rateLimit))
+	switch ipc := getIPC(); {
+	case c.rateLimit > 0:
+		c.rateLimiter.Store(conn.NewRateLimiter(int64(c.rateLimit), int64(c.rateLimit)))
+	case ipc != nil && ipc.decoder != nil:
+		c.rateLimiter.Store(conn.NewRateLimiter(unlimitedRate, unlimitedRate))
+	}
+}
+
+// updateRateLimiter 动态更新全局限速器
+func (c *Common) updateRateLimiter() {
+	if rateLimiter := c.rateLimiter.Load(); rateLimiter != nil {
+		rateLimiter.SetRate(int64(c.rateLimit), int64(c.rateLimit))
+		return
 	}
+	c.initRateLimiter()
 }
 
 // initContext 初始化上下文
@@ -864,7 +965,7 @@ func (c *Common) initTunnelListener() error {
 		if err != nil {
 			return fmt.Errorf("initTunnelListener: listenUDP failed: %w", err)
 		}
-		c.tunnelUDPConn = &conn.StatConn{Conn: tunnelUDPConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter}
+		c.tunnelUDPConn = &conn.StatConn{Conn: tunnelUDPConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
 	}
 
 	return nil
@@ -872,26 +973,76 @@ func (c *Common) initTunnelListener() error {
 
 // initTargetListener 初始化目标监听器
 func (c *Common) initTargetListener() error {
-	if len(c.targetAddrs) == 0 {
+	group := c.targets.Load()
+	if group == nil || len(group.rawAddrs) == 0 {
 		return fmt.Errorf("initTargetListener: no target address")
 	}
+	if c.transparentIngress() && runtime.GOOS != "linux" {
+		return fmt.Errorf("initTargetListener: transparent proxy requires linux")
+	}
 
 	// 初始化目标TCP监听器
-	if len(c.targetTCPAddrs) > 0 && c.disableTCP != "1" {
-		targetListener, err := net.ListenTCP("tcp", c.targetTCPAddrs[0])
+	if len(group.tcpAddrs) > 0 && c.disableTCP != "1" {
+		var targetListener *net.TCPListener
+		var err error
+		if c.ingressMode == ingressTProxy {
+			targetListener, err = listenTransparentTCP(group.tcpAddrs[0])
+		} else {
+			targetListener, err = net.ListenTCP("tcp", group.tcpAddrs[0])
+		}
 		if err != nil {
 			return fmt.Errorf("initTargetListener: listenTCP failed: %w", err)
 		}
 		c.targetListener = targetListener
 	}
 
+	// 初始化透明代理UDP监听器
+	if len(group.udpAddrs) > 0 && c.disableUDP != "1" && c.ingressMode == ingressTProxy {
+		transparentUDP, err := listenTransparentUDP(group.udpAddrs[0], true)
+		if err != nil {
+			if c.targetListener != nil {
+				c.targetListener.Close()
+				c.targetListener = nil
+			}
+			return fmt.Errorf("initTargetListener: %w", err)
+		}
+		c.transparentUDP = transparentUDP
+	}
+
 	// 初始化目标UDP监听器
-	if len(c.targetUDPAddrs) > 0 && c.disableUDP != "1" {
-		targetUDPConn, err := net.ListenUDP("udp", c.targetUDPAddrs[0])
+	if len(group.udpAddrs) > 0 && c.ingressUDP() {
+		targetUDPConn, err := net.ListenUDP("udp", group.udpAddrs[0])
 		if err != nil {
 			return fmt.Errorf("initTargetListener: listenUDP failed: %w", err)
 		}
-		c.targetUDPConn = &conn.StatConn{Conn: targetUDPConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter}
+		c.targetUDPConn = &conn.StatConn{Conn: targetUDPConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
+	}
+
+	// 初始化端口范围与命名映射监听器
+	udpAddr := group.udpAddrs[0]
+	if !c.ingressUDP() {
+		udpAddr = nil
+	}
+	err := c.initPortRange(group.tcpAddrs[0], udpAddr, group.span, "")
+	if err == nil {
+		err = c.initRouteListeners()
+	}
+	if err != nil {
+		c.closePortRange()
+		c.closeRouteListeners()
+		if c.targetListener != nil {
+			c.targetListener.Close()
+			c.targetListener = nil
+		}
+		if c.targetUDPConn != nil {
+			c.targetUDPConn.Close()
+			c.targetUDPConn = nil
+		}
+		if c.transparentUDP != nil {
+			c.transparentUDP.Close()
+			c.transparentUDP = nil
+		}
+		return fmt.Errorf("initTargetListener: %w", err)
 	}
 
 	return nil
@@ -937,6 +1088,16 @@ func (c *Common) stop() {
 		c.logger.Debug("Target connection closed: %v", c.targetUDPConn.LocalAddr())
 	}
 
+	// 关闭透明代理UDP连接
+	if c.transparentUDP != nil {
+		c.transparentUDP.Close()
+		c.logger.Debug("Transparent connection closed: %v", c.transparentUDP.LocalAddr())
+	}
+
+	// 关闭端口范围与命名映射监听器
+	c.closePortRange()
+	c.closeRouteListeners()
+
 	// 关闭隧道UDP连接
 	if c.tunnelUDPConn != nil {
 		c.tunnelUDPConn.Close()
@@ -967,8 +1128,8 @@ func (c *Common) stop() {
 	drain(c.verifyChan)
 
 	// 重置全局限速器
-	if c.rateLimiter != nil {
-		c.rateLimiter.Reset()
+	if rateLimiter := c.rateLimiter.Load(); rateLimiter != nil {
+		rateLimiter.Reset()
 	}
 
 	// 清空DNS缓存
@@ -1022,7 +1183,7 @@ func (c *Common) setControlConn() error {
 			case <-c.ctx.Done():
 				return
 			case data := <-c.writeChan:
-				_, err := c.controlConn.Write(data)
+				_, err := c.controlConn.Write(c.encode(data))
 				if err != nil {
 					c.logger.Error("startWriter: write failed: %v", err)
 				}
@@ -1038,13 +1199,18 @@ func (c *Common) setControlConn() error {
 
 // commonControl 共用控制逻辑
 func (c *Common) commonControl() error {
-	errChan := make(chan error, 3)
+	errChan := make(chan error, 4)
 
 	// 信号消纳、信号队列和健康检查
 	go func() { errChan <- c.commonOnce() }()
 	go func() { errChan <- c.commonQueue() }()
 	go func() { errChan <- c.healthCheck() }()
 
+	// 拨号端目标健康检查
+	if c.targetListener == nil && c.targetUDPConn == nil && c.transparentUDP == nil {
+		go func() { errChan <- c.targetProbeLoop() }()
+	}
+
 	select {
 	case <-c.ctx.Done():
 		return fmt.Errorf("commonControl: context error: %w", c.ctx.Err())
@@ -1062,16 +1228,10 @@ func (c *Common) commonQueue() error {
 			return fmt.Errorf("commonQueue: readBytes failed: %w", err)
 		}
 
-		// 解码信号
+		// 解码信号，认证失败后序列号无法恢复，需重新握手
 		signalData, err := c.decode(rawSignal)
 		if err != nil {
-			c.logger.Error("commonQueue: decode signal failed: %v", err)
-			select {
-			case <-c.ctx.Done():
-				return fmt.Errorf("commonQueue: context error: %w", c.ctx.Err())
-			case <-time.After(contextCheckInterval):
-			}
-			continue
+			return withCode(errCodeControl, fmt.Errorf("commonQueue: %w", err))
 		}
 
 		// 解析JSON信号
@@ -1123,7 +1283,7 @@ func (c *Common) healthCheck() error {
 			// 发送刷新信号到对端
 			if c.ctx.Err() == nil && c.controlConn != nil {
 				signalData, _ := json.Marshal(Signal{ActionType: "flush"})
-				c.writeChan <- c.encode(signalData)
+				c.writeChan <- signalData
 			}
 			c.tunnelPool.Flush()
 			c.tunnelPool.ResetError()
@@ -1141,7 +1301,7 @@ func (c *Common) healthCheck() error {
 		c.checkPoint = time.Now()
 		if c.ctx.Err() == nil && c.controlConn != nil {
 			signalData, _ := json.Marshal(Signal{ActionType: "ping"})
-			c.writeChan <- c.encode(signalData)
+			c.writeChan <- signalData
 		}
 		select {
 		case <-c.ctx.Done():
@@ -1185,12 +1345,7 @@ func (c *Common) incomingVerify() {
 			}
 		}
 	case "client":
-		if conn, ok := testConn.(interface{ ConnectionState() tls.ConnectionState }); ok {
-			state := conn.ConnectionState()
-			if len(state.PeerCertificates) > 0 {
-				fingerprint = c.formatCertFingerprint(state.PeerCertificates[0].Raw)
-			}
-		}
+		fingerprint, _ = c.peerFingerprint(testConn)
 	}
 
 	// 构建并发送验证信号
@@ -1200,7 +1355,7 @@ func (c *Common) incomingVerify() {
 			PoolConnID:  id,
 			Fingerprint: fingerprint,
 		})
-		c.writeChan <- c.encode(signalData)
+		c.writeChan <- signalData
 	}
 
 	c.logger.Debug("TLS verify signal: cid %v -> %v", id, c.controlConn.RemoteAddr())
@@ -1221,10 +1376,31 @@ func (c *Common) commonLoop() {
 			}
 
 			if c.targetListener != nil || c.disableTCP != "1" {
-				go c.commonTCPLoop()
+				go c.commonTCPLoop(c.targetListener, "", 0)
+			}
+			if c.targetUDPConn != nil {
+				go c.commonUDPLoop(c.targetUDPConn, "", 0)
+			}
+			if c.transparentUDP != nil {
+				go c.transparentUDPLoop(c.transparentUDP)
 			}
-			if c.targetUDPConn != nil || c.disableUDP != "1" {
-				go c.commonUDPLoop()
+
+			// 命名映射与端口范围共享连接池和控制连接
+			for _, route := range c.routes {
+				if route.targetListener != nil {
+					go c.commonTCPLoop(route.targetListener, route.name, 0)
+				}
+				if route.targetUDPConn != nil {
+					go c.commonUDPLoop(route.targetUDPConn, route.name, 0)
+				}
+			}
+			for _, port := range c.rangePorts {
+				if port.targetListener != nil {
+					go c.commonTCPLoop(port.targetListener, port.route, port.offset)
+				}
+				if port.targetUDPConn != nil {
+					go c.commonUDPLoop(port.targetUDPConn, port.route, port.offset)
+				}
 			}
 			return
 		}
@@ -1238,10 +1414,10 @@ func (c *Common) commonLoop() {
 }
 
 // commonTCPLoop 共用TCP请求处理循环
-func (c *Common) commonTCPLoop() {
+func (c *Common) commonTCPLoop(targetListener *net.TCPListener, route string, offset int) {
 	for c.ctx.Err() == nil {
 		// 接受来自目标的TCP连接
-		targetConn, err := c.targetListener.Accept()
+		targetConn, err := targetListener.Accept()
 		if err != nil {
 			if c.ctx.Err() != nil || err == net.ErrClosed {
 				return
@@ -1256,19 +1432,52 @@ func (c *Common) commonTCPLoop() {
 			continue
 		}
 
-		targetConn = &conn.StatConn{Conn: targetConn, RX: &c.tcpRX, TX: &c.tcpTX, Rate: c.rateLimiter}
+		// 获取透明代理原始目标
+		var originalDst string
+		if c.transparentIngress() && route == "" && offset == 0 {
+			originalDst, err = c.getTransparentDst(targetConn, targetListener)
+			if err != nil {
+				c.logger.Warn("commonTCPLoop: %v", err)
+				targetConn.Close()
+				continue
+			}
+		}
+
+		targetConn = &conn.StatConn{Conn: targetConn, RX: &c.tcpRX, TX: &c.tcpTX, Rate: c.rateLimiter.Load()}
 		c.logger.Debug("Target connection: %v <-> %v", targetConn.LocalAddr(), targetConn.RemoteAddr())
 
-		go func(targetConn net.Conn) {
+		go func(targetConn net.Conn, originalDst string) {
 			defer func() {
 				if targetConn != nil {
 					targetConn.Close()
 				}
 			}()
 
+			// 解析可信来源的PROXY头部
+			proxiedConn, err := c.acceptProxyHeader(targetConn)
+			if err != nil {
+				c.logger.Warn("commonTCPLoop: acceptProxyHeader failed: %v", err)
+				return
+			}
+			targetConn = proxiedConn
+
+			// 来源访问控制
+			releaseSource, err := c.admitSource(targetConn.RemoteAddr())
+			if err != nil {
+				c.logger.Debug("commonTCPLoop: %v", err)
+				return
+			}
+			defer releaseSource()
+
+			// 单连接与单来源带宽整形
+			shapedConn, releaseShaper := c.shapeConn(targetConn)
+			defer releaseShaper()
+			targetConn = shapedConn
+
 			// 尝试获取TCP连接槽位
 			if !c.tryAcquireSlot(false) {
 				c.logger.Error("commonTCPLoop: TCP slot limit reached: %v/%v", c.tcpSlot, c.slotLimit)
+				atomic.AddUint64(&c.rejects, 1)
 				return
 			}
 
@@ -1282,13 +1491,51 @@ func (c *Common) commonTCPLoop() {
 			}
 			targetConn = wrappedConn
 
+			// 读取SOCKS5或HTTP CONNECT请求目标
+			var request *ingressRequest
+			if c.ingressMode == ingressProxy {
+				request, targetConn, err = c.acceptIngress(targetConn)
+				if err != nil {
+					c.logger.Warn("commonTCPLoop: %v", err)
+					return
+				}
+				if request.associate {
+					c.serveUDPAssociate(targetConn, request, route, offset)
+					return
+				}
+				originalDst = request.target
+			}
+
+			// 按主机名选择命名映射
+			signalRoute := route
+			if route == "" && offset == 0 && c.ingressMode == ingressFixed {
+				signalRoute, targetConn = c.routeVirtualHost(targetConn)
+			}
+
+			// 获取来源TLS服务器名称
+			var serverName string
+			if c.proxyProtocol == proxyV2 {
+				serverName, targetConn = c.peekServerName(targetConn, time.Now().Add(sniPeekTimeout))
+			}
+
 			// 从连接池获取连接
 			id, remoteConn, err := c.tunnelPool.IncomingGet(poolGetTimeout)
 			if err != nil {
 				c.logger.Warn("commonTCPLoop: request timeout: %v", err)
+				if request != nil {
+					request.reply(dialError, nil)
+				}
 				return
 			}
 
+			// 登记拨号结果等待
+			var dialResult chan string
+			if request != nil {
+				dialResult = make(chan string, 1)
+				c.dialResults.Store(id, dialResult)
+				defer c.dialResults.Delete(id)
+			}
+
 			c.logger.Debug("Tunnel connection: get %v <- pool active %v", id, c.tunnelPool.Active())
 
 			defer func() {
@@ -1307,12 +1554,30 @@ func (c *Common) commonTCPLoop() {
 					ActionType: "tcp",
 					RemoteAddr: targetConn.RemoteAddr().String(),
 					PoolConnID: id,
+					ServerName: serverName,
+					Route:      signalRoute,
+					PortOffset: offset,
+					Target:     originalDst,
+					Reply:      request != nil,
 				})
-				c.writeChan <- c.encode(signalData)
+				c.writeChan <- signalData
 			}
 
 			c.logger.Debug("TCP launch signal: cid %v -> %v", id, c.controlConn.RemoteAddr())
 
+			// 按对端拨号结果回复代理握手
+			if request != nil {
+				result := c.awaitDial(dialResult)
+				if err := request.reply(result, nil); err != nil {
+					c.logger.Warn("commonTCPLoop: reply failed: %v", err)
+					return
+				}
+				if result != dialOK {
+					c.logger.Warn("commonTCPLoop: dynamic destination %v: %v", originalDst, result)
+					return
+				}
+			}
+
 			buffer1 := c.getTCPBuffer()
 			buffer2 := c.getTCPBuffer()
 			defer func() {
@@ -1322,18 +1587,18 @@ func (c *Common) commonTCPLoop() {
 
 			// 交换数据
 			c.logger.Info("Starting exchange: %v <-> %v", targetConn.RemoteAddr(), remoteConn.RemoteAddr())
-			c.logger.Info("Exchange complete: %v", conn.DataExchange(targetConn, remoteConn, c.readTimeout, buffer1, buffer2))
-		}(targetConn)
+			c.logger.Info("Exchange complete: %v", conn.DataExchange(targetConn, remoteConn, c.loadReadTimeout(), buffer1, buffer2))
+		}(targetConn, originalDst)
 	}
 }
 
 // commonUDPLoop 共用UDP请求处理循环
-func (c *Common) commonUDPLoop() {
+func (c *Common) commonUDPLoop(targetUDPConn *conn.StatConn, route string, offset int) {
 	for c.ctx.Err() == nil {
 		buffer := c.getUDPBuffer()
 
 		// 读取来自目标的UDP数据
-		x, clientAddr, err := c.targetUDPConn.ReadFromUDP(buffer)
+		x, clientAddr, err := targetUDPConn.ReadFromUDP(buffer)
 		if err != nil {
 			if c.ctx.Err() != nil || err == net.ErrClosed {
 				c.putUDPBuffer(buffer)
@@ -1350,11 +1615,11 @@ func (c *Common) commonUDPLoop() {
 			continue
 		}
 
-		c.logger.Debug("Target connection: %v <-> %v", c.targetUDPConn.LocalAddr(), clientAddr)
+		c.logger.Debug("Target connection: %v <-> %v", targetUDPConn.LocalAddr(), clientAddr)
 
 		var id string
 		var remoteConn net.Conn
-		sessionKey := clientAddr.String()
+		sessionKey := targetSessionKey(route, offset, clientAddr.String())
 
 		// 获取或创建UDP会话
 		if session, ok := c.targetUDPSession.Load(sessionKey); ok {
@@ -1362,9 +1627,19 @@ func (c *Common) commonUDPLoop() {
 			remoteConn = session.(net.Conn)
 			c.logger.Debug("Using UDP session: %v <-> %v", remoteConn.LocalAddr(), remoteConn.RemoteAddr())
 		} else {
+			// 来源访问控制
+			releaseSource, err := c.admitSource(clientAddr)
+			if err != nil {
+				c.logger.Debug("commonUDPLoop: %v", err)
+				c.putUDPBuffer(buffer)
+				continue
+			}
+
 			// 尝试获取UDP连接槽位
 			if !c.tryAcquireSlot(true) {
 				c.logger.Error("commonUDPLoop: UDP slot limit reached: %v/%v", c.udpSlot, c.slotLimit)
+				atomic.AddUint64(&c.rejects, 1)
+				releaseSource()
 				c.putUDPBuffer(buffer)
 				continue
 			}
@@ -1373,6 +1648,7 @@ func (c *Common) commonUDPLoop() {
 			id, remoteConn, err = c.tunnelPool.IncomingGet(poolGetTimeout)
 			if err != nil {
 				c.logger.Warn("commonUDPLoop: request timeout: %v", err)
+				releaseSource()
 				c.releaseSlot(true)
 				c.putUDPBuffer(buffer)
 				continue
@@ -1386,6 +1662,7 @@ func (c *Common) commonUDPLoop() {
 					// 清理UDP会话和释放槽位
 					c.targetUDPSession.Delete(sessionKey)
 					c.releaseSlot(true)
+					releaseSource()
 
 					// 池连接关闭
 					if remoteConn != nil {
@@ -1411,7 +1688,7 @@ func (c *Common) commonUDPLoop() {
 					}
 
 					// 将数据写入目标UDP连接
-					_, err = c.targetUDPConn.WriteToUDP(buffer[:x], clientAddr)
+					_, err = targetUDPConn.WriteToUDP(buffer[:x], clientAddr)
 					if err != nil {
 						if err != io.EOF {
 							c.logger.Error("commonUDPLoop: writeToUDP failed: %v", err)
@@ -1419,7 +1696,7 @@ func (c *Common) commonUDPLoop() {
 						return
 					}
 					// 传输完成
-					c.logger.Debug("Transfer complete: %v <-> %v", remoteConn.LocalAddr(), c.targetUDPConn.LocalAddr())
+					c.logger.Debug("Transfer complete: %v <-> %v", remoteConn.LocalAddr(), targetUDPConn.LocalAddr())
 				}
 			}(remoteConn, clientAddr, sessionKey, id)
 
@@ -1429,12 +1706,14 @@ func (c *Common) commonUDPLoop() {
 					ActionType: "udp",
 					RemoteAddr: clientAddr.String(),
 					PoolConnID: id,
+					Route:      route,
+					PortOffset: offset,
 				})
-				c.writeChan <- c.encode(signalData)
+				c.writeChan <- signalData
 			}
 
 			c.logger.Debug("UDP launch signal: cid %v -> %v", id, c.controlConn.RemoteAddr())
-			c.logger.Debug("Starting transfer: %v <-> %v", remoteConn.LocalAddr(), c.targetUDPConn.LocalAddr())
+			c.logger.Debug("Starting transfer: %v <-> %v", remoteConn.LocalAddr(), targetUDPConn.LocalAddr())
 		}
 
 		// 将原始数据写入池连接
@@ -1450,7 +1729,7 @@ func (c *Common) commonUDPLoop() {
 		}
 
 		// 传输完成
-		c.logger.Debug("Transfer complete: %v <-> %v", remoteConn.LocalAddr(), c.targetUDPConn.LocalAddr())
+		c.logger.Debug("Transfer complete: %v <-> %v", remoteConn.LocalAddr(), targetUDPConn.LocalAddr())
 		c.putUDPBuffer(buffer)
 	}
 }
@@ -1499,18 +1778,21 @@ func (c *Common) commonOnce() error {
 
 					c.logger.Debug("Tunnel pool flushed: %v active connections", c.tunnelPool.Active())
 				}()
+			case "dial":
+				if result, ok := c.dialResults.Load(signal.PoolConnID); ok {
+					select {
+					case result.(chan string) <- signal.Result:
+					default:
+					}
+				}
 			case "ping":
 				if c.ctx.Err() == nil && c.controlConn != nil {
 					signalData, _ := json.Marshal(Signal{ActionType: "pong"})
-					c.writeChan <- c.encode(signalData)
+					c.writeChan <- signalData
 				}
 			case "pong":
 				// 发送检查点事件
-				c.logger.Event("CHECK_POINT|MODE=%v|PING=%vms|POOL=%v|TCPS=%v|UDPS=%v|TCPRX=%v|TCPTX=%v|UDPRX=%v|UDPTX=%v",
-					c.runMode, time.Since(c.checkPoint).Milliseconds(), c.tunnelPool.Active(),
-					atomic.LoadInt32(&c.tcpSlot), atomic.LoadInt32(&c.udpSlot),
-					atomic.LoadUint64(&c.tcpRX), atomic.LoadUint64(&c.tcpTX),
-					atomic.LoadUint64(&c.udpRX), atomic.LoadUint64(&c.udpTX))
+				c.reportCheckPoint(c.collectStats(int(time.Since(c.checkPoint).Milliseconds()), c.tunnelPool.Active()))
 			default:
 				// 无效信号
 			}
@@ -1571,19 +1853,18 @@ func (c *Common) outgoingVerify(signal Signal) {
 		serverFingerprint = c.formatCertFingerprint(cert.Certificate[0])
 		clientFingerprint = fingerPrint
 	case "client":
-		conn, ok := testConn.(interface{ ConnectionState() tls.ConnectionState })
+		fingerprint, ok := c.peerFingerprint(testConn)
 		if !ok {
 			return
 		}
-		state := conn.ConnectionState()
 
-		if len(state.PeerCertificates) == 0 {
+		if fingerprint == "" {
 			c.logger.Error("outgoingVerify: no peer certificates found")
 			c.cancel()
 			return
 		}
 
-		clientFingerprint = c.formatCertFingerprint(state.PeerCertificates[0].Raw)
+		clientFingerprint = fingerprint
 		serverFingerprint = fingerPrint
 	}
 
@@ -1612,6 +1893,7 @@ func (c *Common) commonTCPOnce(signal Signal) {
 	if err != nil {
 		c.logger.Error("commonTCPOnce: request timeout: %v", err)
 		c.tunnelPool.AddError()
+		c.replyDial(signal, err)
 		return
 	}
 
@@ -1630,17 +1912,21 @@ func (c *Common) commonTCPOnce(signal Signal) {
 	// 尝试获取TCP连接槽位
 	if !c.tryAcquireSlot(false) {
 		c.logger.Error("commonTCPOnce: TCP slot limit reached: %v/%v", c.tcpSlot, c.slotLimit)
+		atomic.AddUint64(&c.rejects, 1)
+		c.replyDial(signal, fmt.Errorf("commonTCPOnce: slot limit reached"))
 		return
 	}
 
 	defer c.releaseSlot(false)
 
 	// 连接到目标TCP地址
-	targetConn, err := c.dialWithRotation("tcp", tcpDialTimeout)
+	targetConn, release, err := c.dialSignal(signal, "tcp", tcpDialTimeout)
+	c.replyDial(signal, err)
 	if err != nil {
-		c.logger.Error("commonTCPOnce: dialWithRotation failed: %v", err)
+		c.logger.Error("commonTCPOnce: dialSignal failed: %v", err)
 		return
 	}
+	defer release()
 
 	defer func() {
 		if targetConn != nil {
@@ -1648,12 +1934,12 @@ func (c *Common) commonTCPOnce(signal Signal) {
 		}
 	}()
 
-	targetConn = &conn.StatConn{Conn: targetConn, RX: &c.tcpRX, TX: &c.tcpTX, Rate: c.rateLimiter}
+	targetConn = &conn.StatConn{Conn: targetConn, RX: &c.tcpRX, TX: &c.tcpTX, Rate: c.rateLimiter.Load()}
 	c.logger.Debug("Target connection: %v <-> %v", targetConn.LocalAddr(), targetConn.RemoteAddr())
 
-	// 发送PROXY v1
-	if err := c.sendProxyV1Header(signal.RemoteAddr, targetConn); err != nil {
-		c.logger.Error("commonTCPOnce: sendProxyV1Header failed: %v", err)
+	// 发送PROXY头部
+	if err := c.sendProxyHeader(signal.RemoteAddr, targetConn, signal.PoolConnID, signal.ServerName); err != nil {
+		c.logger.Error("commonTCPOnce: sendProxyHeader failed: %v", err)
 		return
 	}
 
@@ -1666,7 +1952,7 @@ func (c *Common) commonTCPOnce(signal Signal) {
 
 	// 交换数据
 	c.logger.Info("Starting exchange: %v <-> %v", remoteConn.RemoteAddr(), targetConn.RemoteAddr())
-	c.logger.Info("Exchange complete: %v", conn.DataExchange(remoteConn, targetConn, c.readTimeout, buffer1, buffer2))
+	c.logger.Info("Exchange complete: %v", conn.DataExchange(remoteConn, targetConn, c.loadReadTimeout(), buffer1, buffer2))
 }
 
 // commonUDPOnce 共用处理单个UDP请求
@@ -1695,7 +1981,10 @@ func (c *Common) commonUDPOnce(signal Signal) {
 	}()
 
 	var targetConn net.Conn
-	sessionKey := signal.RemoteAddr
+	sessionKey := targetSessionKey(signal.Route, signal.PortOffset, signal.RemoteAddr)
+	if signal.Target != "" {
+		sessionKey += ">" + signal.Target
+	}
 	isNewSession := false
 
 	// 获取或创建目标UDP会话
@@ -1709,17 +1998,28 @@ func (c *Common) commonUDPOnce(signal Signal) {
 		// 尝试获取UDP连接槽位
 		if !c.tryAcquireSlot(true) {
 			c.logger.Error("commonUDPOnce: UDP slot limit reached: %v/%v", c.udpSlot, c.slotLimit)
+			atomic.AddUint64(&c.rejects, 1)
 			return
 		}
 
 		// 创建新的会话
-		newSession, err := c.dialWithRotation("udp", udpDialTimeout)
+		newSession, release, err := c.dialSignal(signal, "udp", udpDialTimeout)
 		if err != nil {
-			c.logger.Error("commonUDPOnce: dialWithRotation failed: %v", err)
+			c.logger.Error("commonUDPOnce: dialSignal failed: %v", err)
 			c.releaseSlot(true)
 			return
 		}
-		targetConn = &conn.StatConn{Conn: newSession, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter}
+		defer release()
+
+		// 附加PROXY v2 数据报头部
+		proxySession, err := c.wrapProxyDatagram(signal.RemoteAddr, newSession, id)
+		if err != nil {
+			c.logger.Error("commonUDPOnce: wrapProxyDatagram failed: %v", err)
+			newSession.Close()
+			c.releaseSlot(true)
+			return
+		}
+		targetConn = &conn.StatConn{Conn: proxySession, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
 		c.targetUDPSession.Store(sessionKey, targetConn)
 		c.logger.Debug("Target connection: %v <-> %v", targetConn.LocalAddr(), targetConn.RemoteAddr())
 	}
@@ -1811,17 +2111,27 @@ func (c *Common) commonUDPOnce(signal Signal) {
 
 // singleControl 单端控制处理循环
 func (c *Common) singleControl() error {
-	errChan := make(chan error, 3)
+	errChan := make(chan error, 3+2*len(c.rangePorts))
 
 	// 启动单端控制、TCP和UDP处理循环
-	if len(c.targetTCPAddrs) > 0 {
+	if c.targets.Load() != nil {
 		go func() { errChan <- c.singleEventLoop() }()
 	}
 	if c.tunnelListener != nil || c.disableTCP != "1" {
-		go func() { errChan <- c.singleTCPLoop() }()
+		go func() { errChan <- c.singleTCPLoop(c.tunnelListener, 0) }()
 	}
 	if c.tunnelUDPConn != nil || c.disableUDP != "1" {
-		go func() { errChan <- c.singleUDPLoop() }()
+		go func() { errChan <- c.singleUDPLoop(c.tunnelUDPConn, 0) }()
+	}
+
+	// 启动端口范围处理循环
+	for _, port := range c.rangePorts {
+		if port.targetListener != nil {
+			go func() { errChan <- c.singleTCPLoop(port.targetListener, port.offset) }()
+		}
+		if port.targetUDPConn != nil {
+			go func() { errChan <- c.singleUDPLoop(port.targetUDPConn, port.offset) }()
+		}
 	}
 
 	select {
@@ -1838,25 +2148,11 @@ func (c *Common) singleEventLoop() error {
 	defer ticker.Stop()
 
 	for c.ctx.Err() == nil {
-		ping := 0
-		now := time.Now()
-
-		// 尝试连接到目标地址检测延迟
-		idx := c.nextTargetIdx()
-		if addr, _ := c.resolveTarget("tcp", idx); addr != nil {
-			if tcpAddr, ok := addr.(*net.TCPAddr); ok {
-				if conn, err := net.DialTimeout("tcp", tcpAddr.String(), reportInterval); err == nil {
-					ping = int(time.Since(now).Milliseconds())
-					conn.Close()
-				}
-			}
-		}
+		// 探测目标地址组检测延迟和健康状态
+		ping := c.probeTargets(c.targets.Load())
 
 		// 发送检查点事件
-		c.logger.Event("CHECK_POINT|MODE=%v|PING=%vms|POOL=0|TCPS=%v|UDPS=%v|TCPRX=%v|TCPTX=%v|UDPRX=%v|UDPTX=%v", c.runMode, ping,
-			atomic.LoadInt32(&c.tcpSlot), atomic.LoadInt32(&c.udpSlot),
-			atomic.LoadUint64(&c.tcpRX), atomic.LoadUint64(&c.tcpTX),
-			atomic.LoadUint64(&c.udpRX), atomic.LoadUint64(&c.udpTX))
+		c.reportCheckPoint(c.collectStats(ping, 0))
 
 		// 等待下一个报告间隔
 		select {
@@ -1870,10 +2166,10 @@ func (c *Common) singleEventLoop() error {
 }
 
 // singleTCPLoop 单端转发TCP处理循环
-func (c *Common) singleTCPLoop() error {
+func (c *Common) singleTCPLoop(tunnelListener net.Listener, offset int) error {
 	for c.ctx.Err() == nil {
 		// 接受来自隧道的TCP连接
-		tunnelConn, err := c.tunnelListener.Accept()
+		tunnelConn, err := tunnelListener.Accept()
 		if err != nil {
 			if c.ctx.Err() != nil || err == net.ErrClosed {
 				return fmt.Errorf("singleTCPLoop: context error: %w", c.ctx.Err())
@@ -1888,7 +2184,7 @@ func (c *Common) singleTCPLoop() error {
 			continue
 		}
 
-		tunnelConn = &conn.StatConn{Conn: tunnelConn, RX: &c.tcpRX, TX: &c.tcpTX, Rate: c.rateLimiter}
+		tunnelConn = &conn.StatConn{Conn: tunnelConn, RX: &c.tcpRX, TX: &c.tcpTX, Rate: c.rateLimiter.Load()}
 		c.logger.Debug("Tunnel connection: %v <-> %v", tunnelConn.LocalAddr(), tunnelConn.RemoteAddr())
 
 		go func(tunnelConn net.Conn) {
@@ -1898,9 +2194,31 @@ func (c *Common) singleTCPLoop() error {
 				}
 			}()
 
+			// 解析可信来源的PROXY头部
+			proxiedConn, err := c.acceptProxyHeader(tunnelConn)
+			if err != nil {
+				c.logger.Warn("singleTCPLoop: acceptProxyHeader failed: %v", err)
+				return
+			}
+			tunnelConn = proxiedConn
+
+			// 来源访问控制
+			releaseSource, err := c.admitSource(tunnelConn.RemoteAddr())
+			if err != nil {
+				c.logger.Debug("singleTCPLoop: %v", err)
+				return
+			}
+			defer releaseSource()
+
+			// 单连接与单来源带宽整形
+			shapedConn, releaseShaper := c.shapeConn(tunnelConn)
+			defer releaseShaper()
+			tunnelConn = shapedConn
+
 			// 尝试获取TCP连接槽位
 			if !c.tryAcquireSlot(false) {
 				c.logger.Error("singleTCPLoop: TCP slot limit reached: %v/%v", c.tcpSlot, c.slotLimit)
+				atomic.AddUint64(&c.rejects, 1)
 				return
 			}
 
@@ -1914,12 +2232,19 @@ func (c *Common) singleTCPLoop() error {
 			}
 			tunnelConn = wrappedConn
 
+			// 获取来源TLS服务器名称
+			var serverName string
+			if c.proxyProtocol == proxyV2 {
+				serverName, tunnelConn = c.peekServerName(tunnelConn, time.Now().Add(sniPeekTimeout))
+			}
+
 			// 尝试建立目标连接
-			targetConn, err := c.dialWithRotation("tcp", tcpDialTimeout)
+			targetConn, release, err := c.dialWithRotation(c.targets.Load(), "tcp", tunnelConn.RemoteAddr().String(), offset, tcpDialTimeout)
 			if err != nil {
 				c.logger.Error("singleTCPLoop: dialWithRotation failed: %v", err)
 				return
 			}
+			defer release()
 
 			defer func() {
 				if targetConn != nil {
@@ -1929,9 +2254,9 @@ func (c *Common) singleTCPLoop() error {
 
 			c.logger.Debug("Target connection: %v <-> %v", targetConn.LocalAddr(), targetConn.RemoteAddr())
 
-			// 发送PROXY v1
-			if err := c.sendProxyV1Header(tunnelConn.RemoteAddr().String(), targetConn); err != nil {
-				c.logger.Error("singleTCPLoop: sendProxyV1Header failed: %v", err)
+			// 发送PROXY头部
+			if err := c.sendProxyHeader(tunnelConn.RemoteAddr().String(), targetConn, "", serverName); err != nil {
+				c.logger.Error("singleTCPLoop: sendProxyHeader failed: %v", err)
 				return
 			}
 
@@ -1944,7 +2269,7 @@ func (c *Common) singleTCPLoop() error {
 
 			// 交换数据
 			c.logger.Info("Starting exchange: %v <-> %v", tunnelConn.RemoteAddr(), targetConn.RemoteAddr())
-			c.logger.Info("Exchange complete: %v", conn.DataExchange(tunnelConn, targetConn, c.readTimeout, buffer1, buffer2))
+			c.logger.Info("Exchange complete: %v", conn.DataExchange(tunnelConn, targetConn, c.loadReadTimeout(), buffer1, buffer2))
 		}(tunnelConn)
 	}
 
@@ -1952,12 +2277,12 @@ func (c *Common) singleTCPLoop() error {
 }
 
 // singleUDPLoop 单端转发UDP处理循环
-func (c *Common) singleUDPLoop() error {
+func (c *Common) singleUDPLoop(tunnelUDPConn *conn.StatConn, offset int) error {
 	for c.ctx.Err() == nil {
 		buffer := c.getUDPBuffer()
 
 		// 读取来自隧道的UDP数据
-		x, clientAddr, err := c.tunnelUDPConn.ReadFromUDP(buffer)
+		x, clientAddr, err := tunnelUDPConn.ReadFromUDP(buffer)
 		if err != nil {
 			if c.ctx.Err() != nil || err == net.ErrClosed {
 				c.putUDPBuffer(buffer)
@@ -1974,10 +2299,11 @@ func (c *Common) singleUDPLoop() error {
 			continue
 		}
 
-		c.logger.Debug("Tunnel connection: %v <-> %v", c.tunnelUDPConn.LocalAddr(), clientAddr)
+		c.logger.Debug("Tunnel connection: %v <-> %v", tunnelUDPConn.LocalAddr(), clientAddr)
 
 		var targetConn net.Conn
-		sessionKey := clientAddr.String()
+		sourceAddr := clientAddr.String()
+		sessionKey := targetSessionKey("", offset, sourceAddr)
 
 		// 获取或创建目标UDP会话
 		if session, ok := c.targetUDPSession.Load(sessionKey); ok {
@@ -1985,23 +2311,45 @@ func (c *Common) singleUDPLoop() error {
 			targetConn = session.(net.Conn)
 			c.logger.Debug("Using UDP session: %v <-> %v", targetConn.LocalAddr(), targetConn.RemoteAddr())
 		} else {
+			// 来源访问控制
+			releaseSource, err := c.admitSource(clientAddr)
+			if err != nil {
+				c.logger.Debug("singleUDPLoop: %v", err)
+				c.putUDPBuffer(buffer)
+				continue
+			}
+
 			// 尝试获取UDP连接槽位
 			if !c.tryAcquireSlot(true) {
 				c.logger.Error("singleUDPLoop: UDP slot limit reached: %v/%v", c.udpSlot, c.slotLimit)
+				atomic.AddUint64(&c.rejects, 1)
+				releaseSource()
 				c.putUDPBuffer(buffer)
 				continue
 			}
 
 			// 创建新的会话
-			newSession, err := c.dialWithRotation("udp", udpDialTimeout)
+			newSession, release, err := c.dialWithRotation(c.targets.Load(), "udp", sourceAddr, offset, udpDialTimeout)
 			if err != nil {
 				c.logger.Error("singleUDPLoop: dialWithRotation failed: %v", err)
+				releaseSource()
+				c.releaseSlot(true)
+				c.putUDPBuffer(buffer)
+				continue
+			}
+
+			// 附加PROXY v2 数据报头部
+			targetConn, err = c.wrapProxyDatagram(sourceAddr, newSession, "")
+			if err != nil {
+				c.logger.Error("singleUDPLoop: wrapProxyDatagram failed: %v", err)
+				newSession.Close()
+				release()
+				releaseSource()
 				c.releaseSlot(true)
 				c.putUDPBuffer(buffer)
 				continue
 			}
-			targetConn = newSession
-			c.targetUDPSession.Store(sessionKey, newSession)
+			c.targetUDPSession.Store(sessionKey, targetConn)
 			c.logger.Debug("Target connection: %v <-> %v", targetConn.LocalAddr(), targetConn.RemoteAddr())
 
 			go func(targetConn net.Conn, clientAddr *net.UDPAddr, sessionKey string) {
@@ -2009,6 +2357,8 @@ func (c *Common) singleUDPLoop() error {
 					if targetConn != nil {
 						targetConn.Close()
 					}
+					release()
+					releaseSource()
 					c.releaseSlot(true)
 				}()
 
@@ -2033,7 +2383,7 @@ func (c *Common) singleUDPLoop() error {
 					}
 
 					// 将响应写回隧道UDP连接
-					_, err = c.tunnelUDPConn.WriteToUDP(buffer[:x], clientAddr)
+					_, err = tunnelUDPConn.WriteToUDP(buffer[:x], clientAddr)
 					if err != nil {
 						if err != io.EOF {
 							c.logger.Error("singleUDPLoop: writeToUDP failed: %v", err)
@@ -2045,13 +2395,13 @@ func (c *Common) singleUDPLoop() error {
 						return
 					}
 					// 传输完成
-					c.logger.Debug("Transfer complete: %v <-> %v", c.tunnelUDPConn.LocalAddr(), targetConn.LocalAddr())
+					c.logger.Debug("Transfer complete: %v <-> %v", tunnelUDPConn.LocalAddr(), targetConn.LocalAddr())
 				}
 			}(targetConn, clientAddr, sessionKey)
 		}
 
 		// 将初始数据发送到目标UDP连接
-		c.logger.Debug("Starting transfer: %v <-> %v", targetConn.LocalAddr(), c.tunnelUDPConn.LocalAddr())
+		c.logger.Debug("Starting transfer: %v <-> %v", targetConn.LocalAddr(), tunnelUDPConn.LocalAddr())
 		_, err = targetConn.Write(buffer[:x])
 		if err != nil {
 			if err != io.EOF {
@@ -2066,7 +2416,7 @@ func (c *Common) singleUDPLoop() error {
 		}
 
 		// 传输完成
-		c.logger.Debug("Transfer complete: %v <-> %v", targetConn.LocalAddr(), c.tunnelUDPConn.LocalAddr())
+		c.logger.Debug("Transfer complete: %v <-> %v", targetConn.LocalAddr(), tunnelUDPConn.LocalAddr())
 		c.putUDPBuffer(buffer)
 	}
 
@@ -0,0 +1,111 @@
+// 内部包，实现控制信道认证加密
+package internal
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// 控制信道常量
+const (
+	controlNonceSize   = 32                        // 握手随机数长度
+	controlNonceHeader = "X-NodePass-Nonce"        // 客户端握手随机数头部
+	controlInfoServer  = "nodepass control server" // 服务端发送密钥标识
+	controlInfoClient  = "nodepass control client" // 客户端发送密钥标识
+)
+
+// controlCipher 控制信道AEAD状态，每个方向独立密钥与序列号
+type controlCipher struct {
+	sealer  cipher.AEAD // 发送方向
+	opener  cipher.AEAD // 接收方向
+	sendSeq uint64      // 发送序列号，仅由写入协程使用
+	recvSeq uint64      // 接收序列号，仅由信号队列使用
+}
+
+// newControlNonce 生成握手随机数
+func newControlNonce() (string, error) {
+	nonce := make([]byte, controlNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("newControlNonce: %w", err)
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// deriveControlAEAD 由隧道密钥与双方随机数派生单向AES-256-GCM
+func (c *Common) deriveControlAEAD(salt []byte, info string) (cipher.AEAD, error) {
+	key, err := hkdf.Key(sha256.New, []byte(c.tunnelKey), salt, info, 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriveControlAEAD: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("deriveControlAEAD: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// initControlCipher 以握手随机数建立控制信道会话密钥
+func (c *Common) initControlCipher(clientNonce, serverNonce string) error {
+	clientBytes, err := hex.DecodeString(clientNonce)
+	if err != nil || len(clientBytes) != controlNonceSize {
+		return fmt.Errorf("initControlCipher: invalid client nonce")
+	}
+	serverBytes, err := hex.DecodeString(serverNonce)
+	if err != nil || len(serverBytes) != controlNonceSize {
+		return fmt.Errorf("initControlCipher: invalid server nonce")
+	}
+	salt := append(clientBytes, serverBytes...)
+
+	sendInfo, recvInfo := controlInfoServer, controlInfoClient
+	if c.coreType == "client" {
+		sendInfo, recvInfo = controlInfoClient, controlInfoServer
+	}
+	sealer, err := c.deriveControlAEAD(salt, sendInfo)
+	if err != nil {
+		return fmt.Errorf("initControlCipher: %w", err)
+	}
+	opener, err := c.deriveControlAEAD(salt, recvInfo)
+	if err != nil {
+		return fmt.Errorf("initControlCipher: %w", err)
+	}
+	c.controlCipher = &controlCipher{sealer: sealer, opener: opener}
+	return nil
+}
+
+// seqNonce 由序列号构造AEAD随机数
+func seqNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// encode 加密信号并以base64行帧输出
+func (c *Common) encode(data []byte) []byte {
+	cc := c.controlCipher
+	sealed := cc.sealer.Seal(nil, seqNonce(cc.sealer, cc.sendSeq), data, nil)
+	cc.sendSeq++
+	return append([]byte(base64.StdEncoding.EncodeToString(sealed)), '\n')
+}
+
+// decode 解密信号，序列号不符的重放、乱序或伪造信号均无法通过认证
+func (c *Common) decode(data []byte) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSuffix(data, []byte{'\n'})))
+	if err != nil {
+		return nil, fmt.Errorf("decode: base64 decode failed: %w", err)
+	}
+	cc := c.controlCipher
+	opened, err := cc.opener.Open(nil, seqNonce(cc.opener, cc.recvSeq), decoded, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decode: authentication failed at seq %v: %w", cc.recvSeq, err)
+	}
+	cc.recvSeq++
+	return opened, nil
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+// newControlPair 以相同隧道密钥与握手随机数建立服务端与客户端控制信道
+func newControlPair(t *testing.T, serverKey, clientKey string) (*Common, *Common) {
+	t.Helper()
+	clientNonce, err := newControlNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverNonce, err := newControlNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Common{coreType: "server", tunnelKey: serverKey}
+	client := &Common{coreType: "client", tunnelKey: clientKey}
+	if err := server.initControlCipher(clientNonce, serverNonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.initControlCipher(clientNonce, serverNonce); err != nil {
+		t.Fatal(err)
+	}
+	return server, client
+}
+
+func TestControlRoundTrip(t *testing.T) {
+	server, client := newControlPair(t, "secret", "secret")
+	for _, signal := range []string{"np://flush", "np://ping", `{"action":"tcp","remote":"1.2.3.4:80"}`} {
+		got, err := client.decode(server.encode([]byte(signal)))
+		if err != nil {
+			t.Fatalf("server to client %q: %v", signal, err)
+		}
+		if string(got) != signal {
+			t.Errorf("server to client = %q, want %q", got, signal)
+		}
+		got, err = server.decode(client.encode([]byte(signal)))
+		if err != nil {
+			t.Fatalf("client to server %q: %v", signal, err)
+		}
+		if string(got) != signal {
+			t.Errorf("client to server = %q, want %q", got, signal)
+		}
+	}
+}
+
+func TestControlRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T) error
+	}{
+		{"replayed frame", func(t *testing.T) error {
+			server, client := newControlPair(t, "secret", "secret")
+			frame := server.encode([]byte("np://ping"))
+			if _, err := client.decode(frame); err != nil {
+				t.Fatal(err)
+			}
+			_, err := client.decode(frame)
+			return err
+		}},
+		{"reordered frames", func(t *testing.T) error {
+			server, client := newControlPair(t, "secret", "secret")
+			server.encode([]byte("np://first"))
+			_, err := client.decode(server.encode([]byte("np://second")))
+			return err
+		}},
+		{"reflected frame", func(t *testing.T) error {
+			server, _ := newControlPair(t, "secret", "secret")
+			_, err := server.decode(server.encode([]byte("np://ping")))
+			return err
+		}},
+		{"tampered frame", func(t *testing.T) error {
+			server, client := newControlPair(t, "secret", "secret")
+			sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(server.encode([]byte("np://ping")))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sealed[0] ^= 1
+			_, err = client.decode([]byte(base64.StdEncoding.EncodeToString(sealed) + "\n"))
+			return err
+		}},
+		{"wrong tunnel key", func(t *testing.T) error {
+			server, client := newControlPair(t, "secret", "other")
+			_, err := client.decode(server.encode([]byte("np://ping")))
+			return err
+		}},
+		{"invalid base64", func(t *testing.T) error {
+			_, client := newControlPair(t, "secret", "secret")
+			_, err := client.decode([]byte("not base64!\n"))
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		if err := tt.run(t); err == nil {
+			t.Errorf("%v: decode succeeded, want error", tt.name)
+		}
+	}
+}
+
+func TestControlDirectionKeys(t *testing.T) {
+	server, client := newControlPair(t, "secret", "secret")
+	serverFrame := server.encode([]byte("np://ping"))
+	clientFrame := client.encode([]byte("np://ping"))
+	if bytes.Equal(serverFrame, clientFrame) {
+		t.Error("both directions produced the same frame for the same plaintext and sequence")
+	}
+}
+
+func TestControlInvalidNonce(t *testing.T) {
+	valid, err := newControlNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                     string
+		clientNonce, serverNonce string
+	}{
+		{"short client nonce", valid[:16], valid},
+		{"short server nonce", valid, valid[:16]},
+		{"non-hex client nonce", "zz" + valid[2:], valid},
+		{"empty server nonce", valid, ""},
+	}
+	for _, tt := range tests {
+		c := &Common{coreType: "server", tunnelKey: "secret"}
+		if err := c.initControlCipher(tt.clientNonce, tt.serverNonce); err == nil {
+			t.Errorf("%v: initControlCipher succeeded, want error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// 内部包，实现主控声明式配置文件
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DeclaredConfig 声明式配置文件
+type DeclaredConfig struct {
+	Instances []*DeclaredInstance `json:"instances" yaml:"instances"` // 期望实例列表
+}
+
+// DeclaredInstance 期望实例，以别名作为唯一标识
+type DeclaredInstance struct {
+	Alias   string            `json:"alias" yaml:"alias"`     // 实例别名
+	URL     string            `json:"url" yaml:"url"`         // 实例URL
+	Restart *bool             `json:"restart" yaml:"restart"` // 是否自启动，默认启用
+	Tags    map[string]string `json:"tags" yaml:"tags"`       // 实例标签
+}
+
+// loadDeclaredConfig 读取并校验声明式配置文件
+func loadDeclaredConfig(filePath string) (map[string]*DeclaredInstance, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("loadDeclaredConfig: read file failed: %w", err)
+	}
+
+	// 按扩展名选择YAML或JSON格式
+	var config DeclaredConfig
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	default:
+		err = json.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loadDeclaredConfig: unmarshal failed: %w", err)
+	}
+
+	declared := make(map[string]*DeclaredInstance, len(config.Instances))
+	for i, instance := range config.Instances {
+		if instance == nil || instance.Alias == "" {
+			return nil, fmt.Errorf("loadDeclaredConfig: instance %v alias required", i)
+		}
+		if len(instance.Alias) > maxValueLen {
+			return nil, fmt.Errorf("loadDeclaredConfig: alias exceeds maximum length %d: %v", maxValueLen, instance.Alias)
+		}
+		if _, exists := declared[instance.Alias]; exists {
+			return nil, fmt.Errorf("loadDeclaredConfig: duplicate alias: %v", instance.Alias)
+		}
+		parsedURL, err := ParseURL(instance.URL)
+		if err != nil || (parsedURL.Scheme != "client" && parsedURL.Scheme != "server") {
+			return nil, fmt.Errorf("loadDeclaredConfig: invalid URL: %v", instance.Alias)
+		}
+		for key, value := range instance.Tags {
+			if len(key) > maxValueLen || len(value) > maxValueLen {
+				return nil, fmt.Errorf("loadDeclaredConfig: tag exceeds maximum length %d: %v", maxValueLen, instance.Alias)
+			}
+		}
+		if instance.Tags == nil {
+			instance.Tags = make(map[string]string)
+		}
+		declared[instance.Alias] = instance
+	}
+	return declared, nil
+}
+
+// reconcileConfig 按配置文件创建、更新和删除受管实例
+func (m *Master) reconcileConfig() {
+	m.declareMu.Lock()
+	defer m.declareMu.Unlock()
+
+	declared, err := loadDeclaredConfig(m.declarePath)
+	if err != nil {
+		m.logger.Error("reconcileConfig: %v", err)
+		return
+	}
+
+	// 收集现有受管实例
+	managed := make(map[string]*Instance)
+	m.instances.Range(func(_, value any) bool {
+		if instance := value.(*Instance); instance.Managed && !instance.deleted {
+			managed[instance.Alias] = instance
+		}
+		return true
+	})
+
+	var created, updated, deleted int
+	for alias, want := range declared {
+		parsedURL, _ := ParseURL(want.URL)
+		instanceType := parsedURL.Scheme
+		restart := want.Restart == nil || *want.Restart
+
+		instance, ok := managed[alias]
+		if !ok {
+			// 创建新实例
+			if _, err := m.createInstance(alias, want.URL, instanceType, restart, true, want.Tags); err != nil {
+				m.logger.Error("reconcileConfig: create failed: %v [%v]", err, alias)
+				continue
+			}
+			created++
+			continue
+		}
+		delete(managed, alias)
+
+		// 按PUT语义更新URL
+		changed := false
+		if enhancedURL := m.enhanceURL(want.URL, instanceType); instance.URL != enhancedURL {
+			m.updateInstanceURL(instance, enhancedURL, instanceType)
+			changed = true
+		}
+
+		// 更新自启动设置和标签
+		if instance.Restart != restart {
+			instance.Restart = restart
+			changed = true
+		}
+		if !maps.Equal(instance.Meta.Tags, want.Tags) {
+			instance.Meta.Tags = want.Tags
+			changed = true
+		}
+		if changed {
+			m.instances.Store(instance.ID, instance)
+			m.sendSSEEvent("update", instance)
+			updated++
+		}
+	}
+
+	// 删除配置文件中已移除的实例
+	for _, instance := range managed {
+		m.deleteInstance(instance)
+		deleted++
+	}
+
+	if created+updated+deleted > 0 {
+		go m.saveState()
+	}
+	m.logger.Info("Config reconciled: %v created, %v updated, %v deleted", created, updated, deleted)
+}
+
+// watchConfig 在文件变化或SIGHUP时重新协调配置
+func (m *Master) watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var lastMod time.Time
+	if info, err := os.Stat(m.declarePath); err == nil {
+		lastMod = info.ModTime()
+	}
+
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			m.logger.Info("Config reload requested: SIGHUP")
+			m.reconcileConfig()
+		case <-ticker.C:
+			info, err := os.Stat(m.declarePath)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+			m.logger.Info("Config file changed: %v", m.declarePath)
+			m.reconcileConfig()
+		case <-m.periodicDone:
+			return
+		}
+	}
+}
@@ -0,0 +1,513 @@
+// 内部包，实现SOCKS5与HTTP CONNECT动态目标入口
+package internal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NodePassProject/conn"
+)
+
+// 入口模式
+const (
+	ingressFixed    = "0" // 固定目标
+	ingressProxy    = "1" // SOCKS5与HTTP CONNECT动态目标
+	ingressRedirect = "2" // iptables REDIRECT透明代理
+	ingressTProxy   = "3" // iptables TPROXY透明代理
+)
+
+// SOCKS5协议常量
+const (
+	socksVersion      = 0x05 // 协议版本
+	socksNoAuth       = 0x00 // 无需认证
+	socksNoAcceptable = 0xFF // 无可用认证方式
+	socksCmdConnect   = 0x01 // CONNECT命令
+	socksCmdAssociate = 0x03 // UDP ASSOCIATE命令
+	socksAtypIPv4     = 0x01 // IPv4地址
+	socksAtypDomain   = 0x03 // 域名地址
+	socksAtypIPv6     = 0x04 // IPv6地址
+	socksRepSuccess   = 0x00 // 成功
+	socksRepFailure   = 0x01 // 一般失败
+	socksRepDenied    = 0x02 // 规则不允许
+	socksRepRefused   = 0x05 // 连接被拒绝
+	socksRepNotAllow  = 0x07 // 不支持的命令
+)
+
+// 动态目标拨号结果
+const (
+	dialOK     = "ok"     // 拨号成功
+	dialDenied = "denied" // 目标未放行
+	dialFailed = "failed" // 对端拨号失败
+	dialError  = "error"  // 本端错误
+)
+
+// errDestDenied 动态目标未放行
+var errDestDenied = errors.New("destination not allowed")
+
+// destAllowlist 动态目标放行列表
+type destAllowlist struct {
+	prefixes []netip.Prefix // 放行网段
+	hosts    []string       // 放行主机名，*.前缀匹配子域名
+}
+
+// ingressRequest 动态目标入口请求
+type ingressRequest struct {
+	target    string                                   // 请求目标地址
+	associate bool                                     // 是否为UDP关联
+	reply     func(result string, bind net.Addr) error // 按拨号结果回复握手
+}
+
+// ingressUDP 判断监听端是否接收普通UDP流量
+func (c *Common) ingressUDP() bool {
+	return c.disableUDP != "1" && c.ingressMode == ingressFixed
+}
+
+// transparentIngress 判断是否为透明代理入口
+func (c *Common) transparentIngress() bool {
+	return c.ingressMode == ingressRedirect || c.ingressMode == ingressTProxy
+}
+
+// getIngressMode 获取入口模式
+func (c *Common) getIngressMode() error {
+	switch ingress := c.parsedURL.Query().Get("ingress"); ingress {
+	case ingressProxy, ingressRedirect, ingressTProxy:
+		c.ingressMode = ingress
+	default:
+		c.ingressMode = ingressFixed
+	}
+	return c.checkIngressAccess(c.parsedURL.Query())
+}
+
+// checkIngressAccess 动态目标入口必须配置来源访问控制
+func (c *Common) checkIngressAccess(query url.Values) error {
+	if c.ingressMode == ingressProxy && query.Get("allow") == "" && query.Get("deny") == "" {
+		return fmt.Errorf("checkIngressAccess: ingress=%v requires allow or deny", ingressProxy)
+	}
+	return nil
+}
+
+// getDestAllowlist 获取动态目标放行列表
+func (c *Common) getDestAllowlist() {
+	allowlist := &destAllowlist{}
+	for entry := range strings.SplitSeq(c.parsedURL.Query().Get("dest"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			allowlist.prefixes = append(allowlist.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			allowlist.prefixes = append(allowlist.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			allowlist.hosts = append(allowlist.hosts, strings.ToLower(entry))
+		}
+	}
+	c.destAllow.Store(allowlist)
+}
+
+// allowed 判断动态目标是否放行
+func (l *destAllowlist) allowed(host string, ip netip.Addr) bool {
+	if l == nil {
+		return false
+	}
+	if containsAddr(l.prefixes, ip) {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range l.hosts {
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// dialDynamic 校验放行列表后拨号动态目标
+func (c *Common) dialDynamic(network, source, target string, timeout time.Duration) (net.Conn, func(), error) {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialDynamic: invalid target %v", target)
+	}
+
+	// 先解析再校验，拨号使用已校验的地址
+	resolved, err := c.resolveAddr(network, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialDynamic: resolve %v failed: %w", target, err)
+	}
+	var addrPort netip.AddrPort
+	switch addr := resolved.(type) {
+	case *net.TCPAddr:
+		addrPort = addr.AddrPort()
+	case *net.UDPAddr:
+		addrPort = addr.AddrPort()
+	}
+	if !c.destAllow.Load().allowed(host, addrPort.Addr().Unmap()) {
+		atomic.AddUint64(&c.rejects, 1)
+		return nil, nil, fmt.Errorf("dialDynamic: %w: %v", errDestDenied, target)
+	}
+
+	group, err := c.parseTargetAddrs(addrPort.String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("dialDynamic: %w", err)
+	}
+	return c.dialWithRotation(group, network, source, 0, timeout)
+}
+
+// awaitDial 等待对端回报动态目标拨号结果
+func (c *Common) awaitDial(result chan string) string {
+	select {
+	case outcome := <-result:
+		return outcome
+	case <-time.After(poolGetTimeout + tcpDialTimeout):
+		return dialError
+	case <-c.ctx.Done():
+		return dialError
+	}
+}
+
+// replyDial 向监听端回报动态目标拨号结果
+func (c *Common) replyDial(signal Signal, err error) {
+	if !signal.Reply || c.ctx.Err() != nil || c.controlConn == nil {
+		return
+	}
+	result := dialOK
+	switch {
+	case errors.Is(err, errDestDenied):
+		result = dialDenied
+	case err != nil:
+		result = dialFailed
+	}
+	signalData, _ := json.Marshal(Signal{ActionType: "dial", PoolConnID: signal.PoolConnID, Result: result})
+	c.writeChan <- signalData
+}
+
+// dialSignal 按启动信号拨号，动态目标优先于目标地址组
+func (c *Common) dialSignal(signal Signal, network string, timeout time.Duration) (net.Conn, func(), error) {
+	if signal.Target != "" {
+		return c.dialDynamic(network, signal.RemoteAddr, signal.Target, timeout)
+	}
+	group := c.routeTargets(signal.Route)
+	if group == nil {
+		return nil, nil, fmt.Errorf("dialSignal: unknown mapping: %v", signal.Route)
+	}
+	return c.dialWithRotation(group, network, signal.RemoteAddr, signal.PortOffset, timeout)
+}
+
+// acceptIngress 完成SOCKS5或HTTP CONNECT握手，读取请求目标
+func (c *Common) acceptIngress(targetConn net.Conn) (*ingressRequest, net.Conn, error) {
+	reader := bufio.NewReader(targetConn)
+	wrapped := &readerConn{Conn: targetConn, reader: reader}
+
+	targetConn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer targetConn.SetReadDeadline(time.Time{})
+
+	version, err := reader.Peek(1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("acceptIngress: %w", err)
+	}
+	if version[0] == socksVersion {
+		request, err := acceptSocks(reader, targetConn)
+		return request, wrapped, err
+	}
+	request, err := acceptHTTPConnect(reader, targetConn)
+	return request, wrapped, err
+}
+
+// acceptSocks 处理SOCKS5无认证握手与请求
+func acceptSocks(reader *bufio.Reader, w io.Writer) (*ingressRequest, error) {
+	// 协商认证方式
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("acceptSocks: read greeting failed: %w", err)
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return nil, fmt.Errorf("acceptSocks: read methods failed: %w", err)
+	}
+	if !slices.Contains(methods, socksNoAuth) {
+		w.Write([]byte{socksVersion, socksNoAcceptable})
+		return nil, fmt.Errorf("acceptSocks: no acceptable auth method")
+	}
+	if _, err := w.Write([]byte{socksVersion, socksNoAuth}); err != nil {
+		return nil, fmt.Errorf("acceptSocks: write method failed: %w", err)
+	}
+
+	// 读取请求命令与目标地址
+	request := make([]byte, 3)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return nil, fmt.Errorf("acceptSocks: read request failed: %w", err)
+	}
+	target, err := readSocksAddr(reader)
+	if err != nil {
+		return nil, fmt.Errorf("acceptSocks: %w", err)
+	}
+
+	reply := func(result string, bind net.Addr) error {
+		rep := byte(socksRepFailure)
+		switch result {
+		case dialOK:
+			rep = socksRepSuccess
+		case dialDenied:
+			rep = socksRepDenied
+		case dialFailed:
+			rep = socksRepRefused
+		}
+		_, err := w.Write(socksReply(rep, bind))
+		return err
+	}
+	switch request[1] {
+	case socksCmdConnect:
+		return &ingressRequest{target: target, reply: reply}, nil
+	case socksCmdAssociate:
+		return &ingressRequest{target: target, associate: true, reply: reply}, nil
+	default:
+		w.Write(socksReply(socksRepNotAllow, nil))
+		return nil, fmt.Errorf("acceptSocks: unsupported command %v", request[1])
+	}
+}
+
+// acceptHTTPConnect 处理HTTP CONNECT请求
+func acceptHTTPConnect(reader *bufio.Reader, w io.Writer) (*ingressRequest, error) {
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		return nil, fmt.Errorf("acceptHTTPConnect: read request failed: %w", err)
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(w, "HTTP/1.1 405 Method Not Allowed\r\nConnection: close\r\n\r\n")
+		return nil, fmt.Errorf("acceptHTTPConnect: unsupported method %v", req.Method)
+	}
+	if _, _, err := net.SplitHostPort(req.Host); err != nil {
+		io.WriteString(w, "HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n")
+		return nil, fmt.Errorf("acceptHTTPConnect: invalid target %v", req.Host)
+	}
+	return &ingressRequest{
+		target: req.Host,
+		reply: func(result string, _ net.Addr) error {
+			status := "502 Bad Gateway"
+			switch result {
+			case dialOK:
+				_, err := io.WriteString(w, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				return err
+			case dialDenied:
+				status = "403 Forbidden"
+			}
+			_, err := io.WriteString(w, "HTTP/1.1 "+status+"\r\nConnection: close\r\n\r\n")
+			return err
+		},
+	}, nil
+}
+
+// readSocksAddr 从流中读取SOCKS5地址
+func readSocksAddr(reader *bufio.Reader) (string, error) {
+	atyp, err := reader.Peek(2)
+	if err != nil {
+		return "", fmt.Errorf("readSocksAddr: %w", err)
+	}
+	size := 0
+	switch atyp[0] {
+	case socksAtypIPv4:
+		size = 1 + net.IPv4len + 2
+	case socksAtypIPv6:
+		size = 1 + net.IPv6len + 2
+	case socksAtypDomain:
+		size = 2 + int(atyp[1]) + 2
+	default:
+		return "", fmt.Errorf("readSocksAddr: unsupported address type %v", atyp[0])
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", fmt.Errorf("readSocksAddr: %w", err)
+	}
+	addr, _, err := parseSocksAddr(buf)
+	return addr, err
+}
+
+// parseSocksAddr 解析SOCKS5地址，返回地址与占用字节数
+func parseSocksAddr(b []byte) (string, int, error) {
+	if len(b) < 1 {
+		return "", 0, fmt.Errorf("parseSocksAddr: short address")
+	}
+	var host string
+	n := 1
+	switch b[0] {
+	case socksAtypIPv4:
+		n += net.IPv4len
+		if len(b) < n+2 {
+			return "", 0, fmt.Errorf("parseSocksAddr: short address")
+		}
+		host = net.IP(b[1:n]).String()
+	case socksAtypIPv6:
+		n += net.IPv6len
+		if len(b) < n+2 {
+			return "", 0, fmt.Errorf("parseSocksAddr: short address")
+		}
+		host = net.IP(b[1:n]).String()
+	case socksAtypDomain:
+		if len(b) < 2 {
+			return "", 0, fmt.Errorf("parseSocksAddr: short address")
+		}
+		n += 1 + int(b[1])
+		if len(b) < n+2 {
+			return "", 0, fmt.Errorf("parseSocksAddr: short address")
+		}
+		host = string(b[2:n])
+	default:
+		return "", 0, fmt.Errorf("parseSocksAddr: unsupported address type %v", b[0])
+	}
+	port := binary.BigEndian.Uint16(b[n : n+2])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), n + 2, nil
+}
+
+// socksReply 构建SOCKS5回复
+func socksReply(rep byte, bind net.Addr) []byte {
+	ip, port := net.IPv4zero, 0
+	if udpAddr, ok := bind.(*net.UDPAddr); ok {
+		ip, port = udpAddr.IP, udpAddr.Port
+	}
+	reply := []byte{socksVersion, rep, 0x00}
+	if ip4 := ip.To4(); ip4 != nil {
+		reply = append(append(reply, socksAtypIPv4), ip4...)
+	} else {
+		reply = append(append(reply, socksAtypIPv6), ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(reply, uint16(port))
+}
+
+// serveUDPAssociate 处理SOCKS5 UDP关联，每个目标地址占用一条池连接
+func (c *Common) serveUDPAssociate(ctrlConn net.Conn, request *ingressRequest, route string, offset int) {
+	// 在控制连接的本地地址上建立中继
+	var localIP net.IP
+	if tcpAddr, ok := ctrlConn.LocalAddr().(*net.TCPAddr); ok {
+		localIP = tcpAddr.IP
+	}
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		c.logger.Error("serveUDPAssociate: listenUDP failed: %v", err)
+		request.reply(dialError, nil)
+		return
+	}
+	relay := &conn.StatConn{Conn: relayConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
+	defer relay.Close()
+	if err := request.reply(dialOK, relayConn.LocalAddr()); err != nil {
+		return
+	}
+
+	clientIP, err := proxyAddrPort(ctrlConn.RemoteAddr())
+	if err != nil {
+		return
+	}
+	c.logger.Debug("UDP associate: %v <-> %v", relay.LocalAddr(), ctrlConn.RemoteAddr())
+
+	// 控制连接关闭时结束关联
+	go func() {
+		io.Copy(io.Discard, ctrlConn)
+		relay.Close()
+	}()
+
+	var sessions sync.Map
+	defer sessions.Range(func(_, value any) bool {
+		value.(net.Conn).Close()
+		return true
+	})
+
+	buffer := c.getUDPBuffer()
+	defer c.putUDPBuffer(buffer)
+
+	for c.ctx.Err() == nil {
+		x, clientAddr, err := relay.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		// 仅接受关联客户端的未分片数据报
+		if clientAddr.AddrPort().Addr().Unmap() != clientIP.Addr().Unmap() || x < 4 || buffer[2] != 0 {
+			continue
+		}
+		target, n, err := parseSocksAddr(buffer[3:x])
+		if err != nil {
+			c.logger.Debug("serveUDPAssociate: %v", err)
+			continue
+		}
+		payload := buffer[3+n : x]
+
+		// 获取或创建目标会话
+		var remoteConn net.Conn
+		if session, ok := sessions.Load(target); ok {
+			remoteConn = session.(net.Conn)
+		} else {
+			if !c.tryAcquireSlot(true) {
+				c.logger.Error("serveUDPAssociate: UDP slot limit reached: %v/%v", c.udpSlot, c.slotLimit)
+				atomic.AddUint64(&c.rejects, 1)
+				continue
+			}
+			id, poolConn, err := c.tunnelPool.IncomingGet(poolGetTimeout)
+			if err != nil {
+				c.logger.Warn("serveUDPAssociate: request timeout: %v", err)
+				c.releaseSlot(true)
+				continue
+			}
+			remoteConn = poolConn
+			sessions.Store(target, remoteConn)
+
+			// 回复数据报携带原始目标地址头部
+			header := append([]byte(nil), buffer[:3+n]...)
+			go func(remoteConn net.Conn, clientAddr *net.UDPAddr, target, id string) {
+				defer func() {
+					sessions.Delete(target)
+					remoteConn.Close()
+					c.releaseSlot(true)
+					c.logger.Debug("Tunnel connection: closed %v", id)
+				}()
+
+				buffer := c.getUDPBuffer()
+				defer c.putUDPBuffer(buffer)
+				reader := &conn.TimeoutReader{Conn: remoteConn, Timeout: udpReadTimeout}
+				for c.ctx.Err() == nil {
+					x, err := reader.Read(buffer[len(header):])
+					if err != nil {
+						return
+					}
+					copy(buffer, header)
+					if _, err := relay.WriteToUDP(buffer[:len(header)+x], clientAddr); err != nil {
+						return
+					}
+				}
+			}(remoteConn, clientAddr, target, id)
+
+			// 构建并发送启动信号
+			if c.ctx.Err() == nil && c.controlConn != nil {
+				signalData, _ := json.Marshal(Signal{
+					ActionType: "udp",
+					RemoteAddr: clientAddr.String(),
+					PoolConnID: id,
+					Route:      route,
+					PortOffset: offset,
+					Target:     target,
+				})
+				c.writeChan <- signalData
+			}
+			c.logger.Debug("UDP launch signal: cid %v -> %v", id, target)
+		}
+
+		if _, err := remoteConn.Write(payload); err != nil && !errors.Is(err, net.ErrClosed) {
+			c.logger.Error("serveUDPAssociate: write to tunnel failed: %v", err)
+		}
+	}
+}
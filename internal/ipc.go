@@ -0,0 +1,326 @@
+// 内部包，实现主控与实例间的进程通信
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// IPC消息类型
+const (
+	ipcTypeStats   = "stats"   // 统计信息
+	ipcTypeEvent   = "event"   // 生命周期事件
+	ipcTypeError   = "error"   // 错误信息
+	ipcTypeCommand = "command" // 主控命令
+	ipcTypeReply   = "reply"   // 命令应答
+)
+
+// IPC主控命令
+const (
+	ipcCommandReload = "reload" // 热重载配置
+)
+
+// IPC生命周期事件
+const (
+	ipcEventStart      = "start"       // 实例启动
+	ipcEventRestart    = "restart"     // 实例重启
+	ipcEventShutdown   = "shutdown"    // 实例关闭
+	ipcEventAuthReject = "auth_reject" // 握手认证被拒绝
+)
+
+// 错误代码
+const (
+	errCodeUnknown   = "unknown"   // 未知错误
+	errCodeListen    = "listen"    // 监听失败
+	errCodeHandshake = "handshake" // 握手失败
+	errCodePool      = "pool"      // 连接池失败
+	errCodeControl   = "control"   // 控制通道失败
+)
+
+// IPC环境变量
+const (
+	ipcFDEnv      = "NP_IPC_FD"      // IPC上报文件描述符
+	ipcCmdFDEnv   = "NP_IPC_CMD_FD"  // IPC命令文件描述符
+	instanceIDEnv = "NP_INSTANCE_ID" // 实例ID
+)
+
+// IPCMessage 进程间通信消息
+type IPCMessage struct {
+	Type    string    `json:"type"`              // 消息类型
+	Stats   *IPCStats `json:"stats,omitempty"`   // 统计信息
+	Event   string    `json:"event,omitempty"`   // 事件名称
+	Code    string    `json:"code,omitempty"`    // 错误代码
+	Message string    `json:"message,omitempty"` // 错误描述
+	Command string    `json:"command,omitempty"` // 命令名称
+	Seq     uint64    `json:"seq,omitempty"`     // 命令编号，应答原样返回
+	URL     string    `json:"url,omitempty"`     // 命令URL
+	Applied []string  `json:"applied,omitempty"` // 已应用配置项
+	Pending []string  `json:"pending,omitempty"` // 需重启配置项
+}
+
+// reloadableParams 支持热重载的查询参数
+var reloadableParams = []string{"rate", "slot", "block", "read", "lb", "allow", "deny", "ipslot", "ipcps", "connrate", "iprate", "dest", "vhost"}
+
+// IPCStats 实例统计信息
+type IPCStats struct {
+	Mode    int32  `json:"mode"`    // 实例模式
+	Ping    int32  `json:"ping"`    // 端内延迟
+	Pool    int32  `json:"pool"`    // 池连接数
+	TCPS    int32  `json:"tcps"`    // TCP连接数
+	UDPS    int32  `json:"udps"`    // UDP连接数
+	TCPRX   uint64 `json:"tcprx"`   // TCP接收字节数
+	TCPTX   uint64 `json:"tcptx"`   // TCP发送字节数
+	UDPRX   uint64 `json:"udprx"`   // UDP接收字节数
+	UDPTX   uint64 `json:"udptx"`   // UDP发送字节数
+	Rejects uint64 `json:"rejects"` // 拒绝连接数
+}
+
+// codedError 携带错误代码的错误
+type codedError struct {
+	code string // 错误代码
+	err  error  // 原始错误
+}
+
+// Error 实现error接口
+func (e *codedError) Error() string { return e.err.Error() }
+
+// Unwrap 返回原始错误
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode 为错误附加错误代码
+func withCode(code string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// errorCode 获取错误代码
+func errorCode(err error) string {
+	var ce *codedError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	return errCodeUnknown
+}
+
+// ipcChannel 实例侧IPC通道
+type ipcChannel struct {
+	mu      sync.Mutex    // 写入互斥锁
+	encoder *json.Encoder // JSON编码器
+	decoder *json.Decoder // 命令解码器
+}
+
+var (
+	ipcOnce sync.Once   // IPC初始化控制
+	ipcConn *ipcChannel // IPC通道实例
+)
+
+// getIPC 获取由主控继承的IPC通道，未启用时返回nil
+func getIPC() *ipcChannel {
+	ipcOnce.Do(func() {
+		fd, err := strconv.Atoi(os.Getenv(ipcFDEnv))
+		if err != nil || fd < 3 {
+			return
+		}
+		file := os.NewFile(uintptr(fd), "ipc")
+		if file == nil {
+			return
+		}
+		ipcConn = &ipcChannel{encoder: json.NewEncoder(file)}
+
+		// 命令通道可选
+		if cmdFD, err := strconv.Atoi(os.Getenv(ipcCmdFDEnv)); err == nil && cmdFD >= 3 {
+			if cmdFile := os.NewFile(uintptr(cmdFD), "ipc-cmd"); cmdFile != nil {
+				ipcConn.decoder = json.NewDecoder(cmdFile)
+			}
+		}
+	})
+	return ipcConn
+}
+
+// send 发送IPC消息
+func (i *ipcChannel) send(msg *IPCMessage) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.encoder.Encode(msg)
+}
+
+// reportEvent 通过IPC报告生命周期事件
+func (c *Common) reportEvent(event string) {
+	if ipc := getIPC(); ipc != nil {
+		ipc.send(&IPCMessage{Type: ipcTypeEvent, Event: event})
+	}
+}
+
+// reportError 通过IPC报告带代码的错误
+func (c *Common) reportError(err error) {
+	if ipc := getIPC(); ipc != nil {
+		ipc.send(&IPCMessage{Type: ipcTypeError, Code: errorCode(err), Message: err.Error()})
+	}
+}
+
+// reportCheckPoint 报告检查点统计，IPC不可用时回退到日志输出
+func (c *Common) reportCheckPoint(stats *IPCStats) {
+	if ipc := getIPC(); ipc != nil {
+		if err := ipc.send(&IPCMessage{Type: ipcTypeStats, Stats: stats}); err == nil {
+			return
+		}
+	}
+	c.logger.Event("CHECK_POINT|MODE=%v|PING=%vms|POOL=%v|TCPS=%v|UDPS=%v|TCPRX=%v|TCPTX=%v|UDPRX=%v|UDPTX=%v|REJECTS=%v",
+		stats.Mode, stats.Ping, stats.Pool, stats.TCPS, stats.UDPS,
+		stats.TCPRX, stats.TCPTX, stats.UDPRX, stats.UDPTX, stats.Rejects)
+}
+
+// collectStats 收集当前统计信息
+func (c *Common) collectStats(ping, pool int) *IPCStats {
+	mode, _ := strconv.ParseInt(c.runMode, 10, 32)
+	return &IPCStats{
+		Mode:    int32(mode),
+		Ping:    int32(ping),
+		Pool:    int32(pool),
+		TCPS:    atomic.LoadInt32(&c.tcpSlot),
+		UDPS:    atomic.LoadInt32(&c.udpSlot),
+		TCPRX:   atomic.LoadUint64(&c.tcpRX),
+		TCPTX:   atomic.LoadUint64(&c.tcpTX),
+		UDPRX:   atomic.LoadUint64(&c.udpRX),
+		UDPTX:   atomic.LoadUint64(&c.udpTX),
+		Rejects: atomic.LoadUint64(&c.rejects),
+	}
+}
+
+// ipcCommandLoop 处理主控下发的命令
+func (c *Common) ipcCommandLoop() {
+	ipc := getIPC()
+	if ipc == nil || ipc.decoder == nil {
+		return
+	}
+
+	for {
+		var msg IPCMessage
+		if err := ipc.decoder.Decode(&msg); err != nil {
+			return
+		}
+		if msg.Type != ipcTypeCommand {
+			continue
+		}
+
+		reply := &IPCMessage{Type: ipcTypeReply, Command: msg.Command, Seq: msg.Seq}
+		switch msg.Command {
+		case ipcCommandReload:
+			applied, pending, err := c.applyReload(msg.URL)
+			if err != nil {
+				reply.Message = err.Error()
+			}
+			reply.Applied, reply.Pending = applied, pending
+		default:
+			reply.Message = fmt.Sprintf("unknown command: %v", msg.Command)
+		}
+		ipc.send(reply)
+	}
+}
+
+// applyReload 热重载可变配置，返回已应用与需重启的配置项
+func (c *Common) applyReload(rawURL string) ([]string, []string, error) {
+	parsedURL, err := ParseURL(rawURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("applyReload: parse URL failed: %w", err)
+	}
+
+	var applied, pending []string
+	c.configMu.RLock()
+	oldURL := c.parsedURL
+	c.configMu.RUnlock()
+
+	// 隧道端点变化需要重启
+	if parsedURL.Scheme != oldURL.Scheme || parsedURL.Host != oldURL.Host || parsedURL.User.String() != oldURL.User.String() {
+		pending = append(pending, "tunnel")
+	}
+
+	// 目标地址仅在本端负责拨号时可热重载
+	reloadTargets := parsedURL.Path != oldURL.Path
+	if reloadTargets {
+		if c.targetListener != nil || c.targetUDPConn != nil || c.transparentUDP != nil {
+			pending = append(pending, "targets")
+			reloadTargets = false
+		} else {
+			applied = append(applied, "targets")
+		}
+	}
+
+	// 比较查询参数
+	oldQuery, newQuery := oldURL.Query(), parsedURL.Query()
+	keys := make([]string, 0, len(oldQuery)+len(newQuery))
+	for key := range oldQuery {
+		keys = append(keys, key)
+	}
+	for key := range newQuery {
+		if !oldQuery.Has(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if slices.Equal(oldQuery[key], newQuery[key]) {
+			continue
+		}
+		if slices.Contains(reloadableParams, key) {
+			applied = append(applied, key)
+		} else {
+			pending = append(pending, key)
+		}
+	}
+
+	// 存在需重启项时不做部分应用
+	if len(pending) > 0 {
+		c.logger.Info("Reload requires restart: %v", strings.Join(pending, ","))
+		return nil, pending, nil
+	}
+
+	// 动态目标入口不允许移除来源访问控制
+	if err := c.checkIngressAccess(parsedURL.Query()); err != nil {
+		return nil, nil, fmt.Errorf("applyReload: %w", err)
+	}
+
+	// 预先解析目标地址
+	var group *targetGroup
+	if reloadTargets {
+		if group, err = c.parseTargetAddrs(strings.TrimPrefix(parsedURL.Path, "/")); err != nil {
+			return nil, nil, fmt.Errorf("applyReload: %w", err)
+		}
+		group.inherit(c.targets.Load())
+
+		// 单端模式监听范围由隧道地址确定，目标范围须与之匹配
+		if c.coreType == "client" && c.runMode == "1" && group.span > 1 && group.span != c.tunnelSpan {
+			return nil, nil, fmt.Errorf("applyReload: target range size %d requires listen range of the same size", group.span)
+		}
+	}
+
+	// 应用可变配置
+	c.configMu.Lock()
+	c.parsedURL = parsedURL
+	c.getReadTimeout()
+	c.getRateLimit()
+	c.getSlotLimit()
+	c.getBlockProtocol()
+	c.getLBStrategy()
+	c.getAccessControl()
+	c.getBandwidthShaping()
+	c.getDestAllowlist()
+	c.getVirtualHosts()
+	if group != nil {
+		c.targets.Store(group)
+	}
+	c.configMu.Unlock()
+	c.updateRateLimiter()
+
+	c.logger.Info("Reload applied: %v", strings.Join(applied, ","))
+	return applied, nil, nil
+}
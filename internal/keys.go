@@ -0,0 +1,232 @@
+// 内部包，实现主控API Key角色权限
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// API Key角色
+const (
+	roleRead     = "read"     // 只读
+	roleOperator = "operator" // 运维操作
+	roleAdmin    = "admin"    // 完全管理
+)
+
+// roleLevels 角色权限等级
+var roleLevels = map[string]int{
+	roleRead:     1,
+	roleOperator: 2,
+	roleAdmin:    3,
+}
+
+// masterKey 主API Key对应的全局管理员身份
+var masterKey = &APIKey{ID: apiKeyID, Name: "master", Role: roleAdmin}
+
+// apiKeyContext 请求上下文中API Key的键
+type apiKeyContext struct{}
+
+// APIKey 命名API Key
+type APIKey struct {
+	ID      string    `json:"id"`      // Key ID
+	Name    string    `json:"name"`    // Key名称
+	Role    string    `json:"role"`    // 角色
+	Scope   KeyScope  `json:"scope"`   // 实例范围
+	Hash    string    `json:"-"`       // Key哈希
+	Created time.Time `json:"created"` // 创建时间
+}
+
+// KeyScope API Key实例范围，均为空时不限制
+type KeyScope struct {
+	IDs  []string          `json:"ids,omitempty"`  // 实例ID列表
+	Tags map[string]string `json:"tags,omitempty"` // 实例标签
+}
+
+// hashAPIKey 计算API Key哈希
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestKey 获取请求对应的API Key
+func requestKey(r *http.Request) *APIKey {
+	key, _ := r.Context().Value(apiKeyContext{}).(*APIKey)
+	return key
+}
+
+// scoped 判断是否限制了实例范围
+func (k *APIKey) scoped() bool {
+	return len(k.Scope.IDs) > 0 || len(k.Scope.Tags) > 0
+}
+
+// permits 判断是否具备指定角色权限
+func (k *APIKey) permits(role string) bool {
+	return k != nil && roleLevels[k.Role] >= roleLevels[role]
+}
+
+// canAccess 判断是否可访问指定实例，API Key实例仅限全局管理员
+func (k *APIKey) canAccess(instance *Instance) bool {
+	if k == nil {
+		return false
+	}
+	if instance.ID == apiKeyID {
+		return k.Role == roleAdmin && !k.scoped()
+	}
+	if !k.scoped() || slices.Contains(k.Scope.IDs, instance.ID) {
+		return true
+	}
+	for name, value := range k.Scope.Tags {
+		if tag, ok := instance.Meta.Tags[name]; ok && tag == value {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredRole 获取请求所需的最低角色
+func (m *Master) requiredRole(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, m.prefix)
+	switch {
+	case strings.HasPrefix(path, "/keys"), strings.HasPrefix(path, "/state/"), path == "/audit":
+		return roleAdmin
+	case path == "/tcping":
+		return roleOperator
+	case r.Method == http.MethodGet:
+		return roleRead
+	case r.Method == http.MethodPatch || r.Method == http.MethodPut:
+		return roleOperator
+	default:
+		return roleAdmin
+	}
+}
+
+// authenticate 校验请求携带的Key，返回对应身份
+func (m *Master) authenticate(apiKeyInstance *Instance, secret string) *APIKey {
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(apiKeyInstance.URL)) == 1 {
+		return masterKey
+	}
+
+	hash := hashAPIKey(secret)
+	m.keysMu.RLock()
+	defer m.keysMu.RUnlock()
+	for _, key := range apiKeyInstance.Keys {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(key.Hash)) == 1 {
+			return key
+		}
+	}
+	return nil
+}
+
+// withRequestKey 将API Key写入请求上下文
+func withRequestKey(r *http.Request, key *APIKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiKeyContext{}, key))
+}
+
+// handleKeys 处理API Key集合请求
+func (m *Master) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if requestKey(r).scoped() {
+		httpError(w, "Forbidden: scoped key", http.StatusForbidden)
+		return
+	}
+
+	apiKeyInstance, ok := m.findInstance(apiKeyID)
+	if !ok {
+		httpError(w, "API Key not found", http.StatusNotFound)
+		return
+	}
+
+	// 获取单个Key ID
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, fmt.Sprintf("%s/keys", m.prefix)), "/")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		// 按创建时间列出所有Key
+		m.keysMu.RLock()
+		keys := slices.Collect(maps.Values(apiKeyInstance.Keys))
+		m.keysMu.RUnlock()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].Created.Before(keys[j].Created) })
+		writeJSON(w, http.StatusOK, keys)
+
+	case r.Method == http.MethodPost && id == "":
+		var reqData struct {
+			Name  string   `json:"name"`
+			Role  string   `json:"role"`
+			Scope KeyScope `json:"scope"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil || reqData.Name == "" {
+			httpError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(reqData.Name) > maxValueLen {
+			httpError(w, fmt.Sprintf("Key name exceeds maximum length %d", maxValueLen), http.StatusBadRequest)
+			return
+		}
+		if _, ok := roleLevels[reqData.Role]; !ok {
+			httpError(w, fmt.Sprintf("Invalid role: %s", reqData.Role), http.StatusBadRequest)
+			return
+		}
+
+		// 创建Key，明文仅在此返回一次
+		secret := generateAPIKey()
+		key := &APIKey{
+			ID:      generateID(),
+			Name:    reqData.Name,
+			Role:    reqData.Role,
+			Scope:   reqData.Scope,
+			Hash:    hashAPIKey(secret),
+			Created: time.Now(),
+		}
+
+		m.keysMu.Lock()
+		keys := maps.Clone(apiKeyInstance.Keys)
+		if keys == nil {
+			keys = make(map[string]*APIKey)
+		}
+		keys[key.ID] = key
+		apiKeyInstance.Keys = keys
+		m.keysMu.Unlock()
+
+		auditResult(r, map[string]any{"id": key.ID, "name": key.Name, "role": key.Role, "scope": key.Scope})
+		go m.saveState()
+		m.logger.Info("API Key added: %v %v [%v]", key.Name, key.Role, key.ID)
+		writeJSON(w, http.StatusCreated, struct {
+			*APIKey
+			Key string `json:"key"`
+		}{key, secret})
+
+	case r.Method == http.MethodDelete && id != "":
+		m.keysMu.Lock()
+		key, exists := apiKeyInstance.Keys[id]
+		if exists {
+			keys := maps.Clone(apiKeyInstance.Keys)
+			delete(keys, id)
+			apiKeyInstance.Keys = keys
+		}
+		m.keysMu.Unlock()
+
+		if !exists {
+			httpError(w, "Key not found", http.StatusNotFound)
+			return
+		}
+
+		auditTrack(r, "", map[string]any{"id": key.ID, "name": key.Name, "role": key.Role, "scope": key.Scope})
+		go m.saveState()
+		// 仅断开使用该Key的SSE连接
+		go m.closeKeySSEConnections(key.ID)
+		m.logger.Info("API Key revoked: %v [%v]", key.Name, key.ID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
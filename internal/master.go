@@ -6,11 +6,11 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
-	"encoding/gob"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
 	"net/url"
@@ -20,25 +20,25 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
-
-	"github.com/NodePassProject/logs"
 )
 
 // 常量定义
 const (
 	openAPIVersion  = "v1"                   // OpenAPI版本
-	stateFilePath   = "gob"                  // 实例状态持久化文件路径
-	stateFileName   = "nodepass.gob"         // 实例状态持久化文件名
+	stateFilePath   = "state"                // 实例状态持久化文件路径
+	stateFileName   = "nodepass.json"        // 实例状态持久化文件名
 	sseRetryTime    = 3000                   // 重试间隔时间（毫秒）
 	apiKeyID        = "********"             // API Key的特殊ID
 	tcpingSemLimit  = 10                     // TCPing最大并发数
 	baseDuration    = 100 * time.Millisecond // 基准持续时间
 	gracefulTimeout = 5 * time.Second        // 优雅关闭超时
+	reloadTimeout   = 5 * time.Second        // 热重载应答超时
 	maxValueLen     = 256                    // 字符长度限制
 )
 
@@ -80,6 +80,12 @@ type Master struct {
 	masterURL     *url.URL            // 主控URL
 	statePath     string              // 实例状态持久化文件路径
 	stateMu       sync.Mutex          // 持久化文件写入互斥锁
+	keysMu        sync.RWMutex        // API Key列表互斥锁
+	auditPath     string              // 审计日志文件路径
+	auditMu       sync.Mutex          // 审计日志写入与轮转互斥锁
+	quotaMu       sync.Mutex          // 流量配额状态互斥锁
+	declarePath   string              // 声明式配置文件路径
+	declareMu     sync.Mutex          // 配置协调互斥锁
 	subscribers   sync.Map            // SSE订阅者映射表
 	notifyChannel chan *InstanceEvent // 事件通知通道
 	tcpingSem     chan struct{}       // TCPing并发控制
@@ -87,6 +93,12 @@ type Master struct {
 	periodicDone  chan struct{}       // 定期任务停止信号
 }
 
+// sseSubscriber SSE订阅者
+type sseSubscriber struct {
+	keyID  string              // 所用API Key ID
+	events chan *InstanceEvent // 事件通道
+}
+
 // Instance 实例信息
 type Instance struct {
 	ID             string             `json:"id"`        // 实例ID
@@ -106,6 +118,11 @@ type Instance struct {
 	TCPTX          uint64             `json:"tcptx"`     // TCP发送字节数
 	UDPRX          uint64             `json:"udprx"`     // UDP接收字节数
 	UDPTX          uint64             `json:"udptx"`     // UDP发送字节数
+	Restarts       uint64             `json:"restarts"`  // 重启次数
+	Rejects        uint64             `json:"rejects"`   // 拒绝连接数
+	Quota          *Quota             `json:"quota"`     // 流量配额
+	Managed        bool               `json:"managed"`   // 是否由配置文件管理
+	Keys           map[string]*APIKey `json:"-"`         // 命名API Key（仅API Key实例）
 	TCPRXBase      uint64             `json:"-" gob:"-"` // TCP接收字节数基线（不序列化）
 	TCPTXBase      uint64             `json:"-" gob:"-"` // TCP发送字节数基线（不序列化）
 	UDPRXBase      uint64             `json:"-" gob:"-"` // UDP接收字节数基线（不序列化）
@@ -114,10 +131,16 @@ type Instance struct {
 	TCPTXReset     uint64             `json:"-" gob:"-"` // TCP发送重置偏移量（不序列化）
 	UDPRXReset     uint64             `json:"-" gob:"-"` // UDP接收重置偏移量（不序列化）
 	UDPTXReset     uint64             `json:"-" gob:"-"` // UDP发送重置偏移量（不序列化）
+	RejectsBase    uint64             `json:"-" gob:"-"` // 拒绝连接数基线（不序列化）
 	cmd            *exec.Cmd          `json:"-" gob:"-"` // 命令对象（不序列化）
 	stopped        chan struct{}      `json:"-" gob:"-"` // 停止信号通道（不序列化）
 	deleted        bool               `json:"-" gob:"-"` // 删除标志（不序列化）
+	restarting     bool               `json:"-" gob:"-"` // 重启标志（不序列化）
 	cancelFunc     context.CancelFunc `json:"-" gob:"-"` // 取消函数（不序列化）
+	ipcCmd         *os.File           `json:"-" gob:"-"` // IPC命令写端（不序列化）
+	ipcReply       chan *IPCMessage   `json:"-" gob:"-"` // IPC命令应答（不序列化）
+	ipcMu          sync.Mutex         `json:"-" gob:"-"` // IPC命令互斥锁（不序列化）
+	ipcSeq         uint64             `json:"-" gob:"-"` // IPC命令编号（不序列化）
 	lastCheckPoint time.Time          `json:"-" gob:"-"` // 上次检查点时间（不序列化）
 }
 
@@ -136,7 +159,7 @@ type Peer struct {
 
 // InstanceEvent 实例事件信息
 type InstanceEvent struct {
-	Type     string    `json:"type"`     // 事件类型：initial, create, update, delete, shutdown, log
+	Type     string    `json:"type"`     // 事件类型：initial, create, update, delete, shutdown, log, quota, auth
 	Time     time.Time `json:"time"`     // 事件时间
 	Instance *Instance `json:"instance"` // 关联的实例
 	Logs     string    `json:"logs"`     // 日志内容
@@ -222,6 +245,7 @@ type InstanceLogWriter struct {
 	target     io.Writer      // 目标写入器
 	master     *Master        // 主控对象
 	checkPoint *regexp.Regexp // 检查点正则表达式
+	ipc        bool           // 是否启用IPC通道
 }
 
 // NewInstanceLogWriter 创建新的实例日志写入器
@@ -231,7 +255,7 @@ func NewInstanceLogWriter(instanceID string, instance *Instance, target io.Write
 		instance:   instance,
 		target:     target,
 		master:     master,
-		checkPoint: regexp.MustCompile(`CHECK_POINT\|MODE=(\d+)\|PING=(\d+)ms\|POOL=(\d+)\|TCPS=(\d+)\|UDPS=(\d+)\|TCPRX=(\d+)\|TCPTX=(\d+)\|UDPRX=(\d+)\|UDPTX=(\d+)`),
+		checkPoint: regexp.MustCompile(`CHECK_POINT\|MODE=(\d+)\|PING=(\d+)ms\|POOL=(\d+)\|TCPS=(\d+)\|UDPS=(\d+)\|TCPRX=(\d+)\|TCPTX=(\d+)\|UDPRX=(\d+)\|UDPTX=(\d+)(?:\|REJECTS=(\d+))?`),
 	}
 }
 
@@ -242,66 +266,30 @@ func (w *InstanceLogWriter) Write(p []byte) (n int, err error) {
 
 	for scanner.Scan() {
 		line := scanner.Text()
-		// 解析并处理检查点信息
-		if matches := w.checkPoint.FindStringSubmatch(line); len(matches) == 10 {
-			// matches[1] = MODE, matches[2] = PING, matches[3] = POOL, matches[4] = TCPS, matches[5] = UDPS, matches[6] = TCPRX, matches[7] = TCPTX, matches[8] = UDPRX, matches[9] = UDPTX
-			if mode, err := strconv.ParseInt(matches[1], 10, 32); err == nil {
-				w.instance.Mode = int32(mode)
-			}
-			if ping, err := strconv.ParseInt(matches[2], 10, 32); err == nil {
-				w.instance.Ping = int32(ping)
-			}
-			if pool, err := strconv.ParseInt(matches[3], 10, 32); err == nil {
-				w.instance.Pool = int32(pool)
-			}
-			if tcps, err := strconv.ParseInt(matches[4], 10, 32); err == nil {
-				w.instance.TCPS = int32(tcps)
-			}
-			if udps, err := strconv.ParseInt(matches[5], 10, 32); err == nil {
-				w.instance.UDPS = int32(udps)
-			}
-
-			stats := []*uint64{&w.instance.TCPRX, &w.instance.TCPTX, &w.instance.UDPRX, &w.instance.UDPTX}
-			bases := []uint64{w.instance.TCPRXBase, w.instance.TCPTXBase, w.instance.UDPRXBase, w.instance.UDPTXBase}
-			resets := []*uint64{&w.instance.TCPRXReset, &w.instance.TCPTXReset, &w.instance.UDPRXReset, &w.instance.UDPTXReset}
-			for i, stat := range stats {
-				if v, err := strconv.ParseUint(matches[i+6], 10, 64); err == nil {
-					// 累计值 = 基线 + 检查点值 - 重置偏移
-					if v >= *resets[i] {
-						*stat = bases[i] + v - *resets[i]
-					} else {
-						// 发生重启，更新算法，清零偏移
-						*stat = bases[i] + v
-						*resets[i] = 0
+		// 解析并处理检查点信息，启用IPC时由IPC通道负责
+		if !w.ipc {
+			if matches := w.checkPoint.FindStringSubmatch(line); len(matches) == 11 {
+				// matches[1] = MODE, matches[2] = PING, matches[3] = POOL, matches[4] = TCPS, matches[5] = UDPS, matches[6] = TCPRX, matches[7] = TCPTX, matches[8] = UDPRX, matches[9] = UDPTX, matches[10] = REJECTS
+				stats := &IPCStats{}
+				for i, v := range []*int32{&stats.Mode, &stats.Ping, &stats.Pool, &stats.TCPS, &stats.UDPS} {
+					if n, err := strconv.ParseInt(matches[i+1], 10, 32); err == nil {
+						*v = int32(n)
 					}
 				}
+				for i, v := range []*uint64{&stats.TCPRX, &stats.TCPTX, &stats.UDPRX, &stats.UDPTX, &stats.Rejects} {
+					if n, err := strconv.ParseUint(matches[i+6], 10, 64); err == nil {
+						*v = n
+					}
+				}
+				w.applyCheckPoint(stats)
+				// 过滤检查点日志
+				continue
 			}
 
-			w.instance.lastCheckPoint = time.Now()
-
-			// 自动恢复运行状态
-			if w.instance.Status == "error" {
-				w.instance.Status = "running"
-			}
-
-			// 仅当实例未被删除时才存储和发送更新事件
-			if !w.instance.deleted {
-				w.master.instances.Store(w.instanceID, w.instance)
-				w.master.sendSSEEvent("update", w.instance)
+			// 检测实例错误并标记状态
+			if strings.Contains(line, "Server error:") || strings.Contains(line, "Client error:") {
+				w.markError()
 			}
-			// 过滤检查点日志
-			continue
-		}
-
-		// 检测实例错误并标记状态
-		if w.instance.Status != "error" && !w.instance.deleted &&
-			(strings.Contains(line, "Server error:") || strings.Contains(line, "Client error:")) {
-			w.instance.Status = "error"
-			w.instance.Ping = 0
-			w.instance.Pool = 0
-			w.instance.TCPS = 0
-			w.instance.UDPS = 0
-			w.master.instances.Store(w.instanceID, w.instance)
 		}
 
 		// 输出日志加实例ID
@@ -319,6 +307,98 @@ func (w *InstanceLogWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// applyCheckPoint 应用检查点统计信息
+func (w *InstanceLogWriter) applyCheckPoint(stats *IPCStats) {
+	w.instance.Mode = stats.Mode
+	w.instance.Ping = stats.Ping
+	w.instance.Pool = stats.Pool
+	w.instance.TCPS = stats.TCPS
+	w.instance.UDPS = stats.UDPS
+
+	values := []uint64{stats.TCPRX, stats.TCPTX, stats.UDPRX, stats.UDPTX}
+	totals := []*uint64{&w.instance.TCPRX, &w.instance.TCPTX, &w.instance.UDPRX, &w.instance.UDPTX}
+	bases := []uint64{w.instance.TCPRXBase, w.instance.TCPTXBase, w.instance.UDPRXBase, w.instance.UDPTXBase}
+	resets := []*uint64{&w.instance.TCPRXReset, &w.instance.TCPTXReset, &w.instance.UDPRXReset, &w.instance.UDPTXReset}
+	for i, v := range values {
+		// 累计值 = 基线 + 检查点值 - 重置偏移
+		if v >= *resets[i] {
+			*totals[i] = bases[i] + v - *resets[i]
+		} else {
+			// 发生重启，更新算法，清零偏移
+			*totals[i] = bases[i] + v
+			*resets[i] = 0
+		}
+	}
+
+	w.instance.Rejects = w.instance.RejectsBase + stats.Rejects
+	w.instance.lastCheckPoint = time.Now()
+
+	// 累计流量配额
+	if !w.instance.deleted {
+		w.master.checkQuota(w.instance)
+	}
+
+	// 自动恢复运行状态
+	if w.instance.Status == "error" {
+		w.instance.Status = "running"
+	}
+
+	// 仅当实例未被删除时才存储和发送更新事件
+	if !w.instance.deleted {
+		w.master.instances.Store(w.instanceID, w.instance)
+		w.master.sendSSEEvent("update", w.instance)
+	}
+}
+
+// markError 标记实例错误状态
+func (w *InstanceLogWriter) markError() {
+	if w.instance.Status == "error" || w.instance.deleted {
+		return
+	}
+	w.instance.Status = "error"
+	w.instance.Ping = 0
+	w.instance.Pool = 0
+	w.instance.TCPS = 0
+	w.instance.UDPS = 0
+	w.master.instances.Store(w.instanceID, w.instance)
+}
+
+// readIPC 读取实例IPC消息
+func (w *InstanceLogWriter) readIPC(r io.ReadCloser, cmdWriter io.Closer) {
+	defer r.Close()
+	defer cmdWriter.Close()
+	decoder := json.NewDecoder(r)
+	for {
+		var msg IPCMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err != io.EOF {
+				w.master.logger.Debug("readIPC: decode failed: %v [%v]", err, w.instanceID)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case ipcTypeStats:
+			if msg.Stats != nil {
+				w.applyCheckPoint(msg.Stats)
+			}
+		case ipcTypeError:
+			w.master.logger.Debug("Instance error: code=%v message=%v [%v]", msg.Code, msg.Message, w.instanceID)
+			w.markError()
+		case ipcTypeEvent:
+			w.master.logger.Debug("Instance event: %v [%v]", msg.Event, w.instanceID)
+			if msg.Event == ipcEventAuthReject && !w.instance.deleted {
+				w.master.sendSSEEvent("auth", w.instance, msg.Message)
+			}
+		case ipcTypeReply:
+			select {
+			case w.instance.ipcReply <- &msg:
+			default:
+			}
+		}
+	}
+}
+
 // setCorsHeaders 设置跨域响应头
 func setCorsHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -327,7 +407,7 @@ func setCorsHeaders(w http.ResponseWriter) {
 }
 
 // NewMaster 创建新的主控实例
-func NewMaster(parsedURL *url.URL, tlsCode string, tlsConfig *tls.Config, logger *logs.Logger, version string) (*Master, error) {
+func NewMaster(parsedURL *url.URL, tlsCode string, tlsConfig *tls.Config, logger Logger, version string) (*Master, error) {
 	// 解析主机地址
 	host, err := net.ResolveTCPAddr("tcp", parsedURL.Host)
 	if err != nil {
@@ -354,6 +434,14 @@ func NewMaster(parsedURL *url.URL, tlsCode string, tlsConfig *tls.Config, logger
 	execPath, _ := os.Executable()
 	baseDir := filepath.Dir(execPath)
 
+	// ACME证书供服务端实例按文件加载
+	crtPath, keyPath := parsedURL.Query().Get("crt"), parsedURL.Query().Get("key")
+	if parsedURL.Query().Get("tls") == "acme" {
+		if acmeCrt, acmeKey, err := ACMECertFiles(parsedURL); err == nil {
+			crtPath, keyPath = acmeCrt, acmeKey
+		}
+	}
+
 	master := &Master{
 		Common: Common{
 			tlsCode: tlsCode,
@@ -362,12 +450,14 @@ func NewMaster(parsedURL *url.URL, tlsCode string, tlsConfig *tls.Config, logger
 		prefix:        fmt.Sprintf("%s/%s", prefix, openAPIVersion),
 		version:       version,
 		logLevel:      parsedURL.Query().Get("log"),
-		crtPath:       parsedURL.Query().Get("crt"),
-		keyPath:       parsedURL.Query().Get("key"),
+		crtPath:       crtPath,
+		keyPath:       keyPath,
 		hostname:      hostname,
 		tlsConfig:     tlsConfig,
 		masterURL:     parsedURL,
 		statePath:     filepath.Join(baseDir, stateFilePath, stateFileName),
+		auditPath:     filepath.Join(baseDir, stateFilePath, auditFileName),
+		declarePath:   parsedURL.Query().Get("config"),
 		notifyChannel: make(chan *InstanceEvent, semaphoreLimit),
 		tcpingSem:     make(chan struct{}, tcpingSemLimit),
 		startTime:     time.Now(),
@@ -416,6 +506,12 @@ func (m *Master) Run() {
 		m.logger.Info("API Key loaded: %v", apiKey.URL)
 	}
 
+	// 按声明式配置文件协调实例
+	if m.declarePath != "" {
+		m.reconcileConfig()
+		go m.watchConfig()
+	}
+
 	// 设置HTTP路由
 	mux := http.NewServeMux()
 
@@ -426,6 +522,11 @@ func (m *Master) Run() {
 		fmt.Sprintf("%s/events", m.prefix):     m.handleSSE,
 		fmt.Sprintf("%s/info", m.prefix):       m.handleInfo,
 		fmt.Sprintf("%s/tcping", m.prefix):     m.handleTCPing,
+		fmt.Sprintf("%s/metrics", m.prefix):    m.handleMetrics,
+		fmt.Sprintf("%s/keys", m.prefix):       m.handleKeys,
+		fmt.Sprintf("%s/keys/", m.prefix):      m.handleKeys,
+		fmt.Sprintf("%s/audit", m.prefix):      m.handleAudit,
+		fmt.Sprintf("%s/state/", m.prefix):     m.handleState,
 	}
 
 	// 创建不需要API Key认证的端点
@@ -445,6 +546,7 @@ func (m *Master) Run() {
 			}
 
 			// 读取API Key，如果存在的话
+			key := masterKey
 			apiKeyInstance, keyExists := m.findInstance(apiKeyID)
 			if keyExists && apiKeyInstance.URL != "" {
 				// 检查请求头中的API Key
@@ -456,14 +558,32 @@ func (m *Master) Run() {
 				}
 
 				// 验证API Key
-				if reqAPIKey != apiKeyInstance.URL {
+				if key = m.authenticate(apiKeyInstance, reqAPIKey); key == nil {
 					httpError(w, "Unauthorized: Invalid API key", http.StatusUnauthorized)
 					return
 				}
 			}
 
+			// 记录变更请求审计日志
+			if auditable(r) {
+				var entry *AuditEntry
+				r, entry = m.newAuditEntry(r, key)
+				recorder := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+				w = recorder
+				defer func() {
+					entry.Status = recorder.status
+					m.writeAudit(entry)
+				}()
+			}
+
+			// 验证角色权限
+			if !key.permits(m.requiredRole(r)) {
+				httpError(w, "Forbidden: insufficient role", http.StatusForbidden)
+				return
+			}
+
 			// 调用原始处理器
-			next(w, r)
+			next(w, withRequestKey(r, key))
 		}
 	}
 
@@ -560,7 +680,7 @@ func (m *Master) Shutdown(ctx context.Context) error {
 
 		// 保存实例状态
 		if err := m.saveState(); err != nil {
-			m.logger.Error("shutdown: save gob failed: %v", err)
+			m.logger.Error("shutdown: save state failed: %v", err)
 		} else {
 			m.logger.Info("Instances saved: %v", m.statePath)
 		}
@@ -576,9 +696,14 @@ func (m *Master) Shutdown(ctx context.Context) error {
 func (m *Master) startPeriodicTasks() {
 	ticker := time.NewTicker(ReloadInterval)
 	defer ticker.Stop()
+	quotaTicker := time.NewTicker(quotaCheckInterval)
+	defer quotaTicker.Stop()
 
 	for {
 		select {
+		case <-quotaTicker.C:
+			// 执行配额周期重置
+			m.performQuotaReset()
 		case <-ticker.C:
 			// 执行定期备份
 			m.performPeriodicBackup()
@@ -660,9 +785,7 @@ func (m *Master) performPeriodicRestart() {
 
 	// 重启所有error状态的实例
 	for _, instance := range errorInstances {
-		m.stopInstance(instance)
-		time.Sleep(baseDuration)
-		m.startInstance(instance)
+		m.restartInstance(instance)
 	}
 }
 
@@ -679,17 +802,10 @@ func (m *Master) saveStateToPath(filePath string) error {
 	defer m.stateMu.Unlock()
 
 	// 创建持久化数据
-	persistentData := make(map[string]*Instance)
-
-	// 从sync.Map转换数据
-	m.instances.Range(func(key, value any) bool {
-		instance := value.(*Instance)
-		persistentData[key.(string)] = instance
-		return true
-	})
+	state := m.snapshotState()
 
 	// 如果没有实例，直接返回
-	if len(persistentData) == 0 {
+	if len(state.Instances) == 0 {
 		// 如果状态文件存在，删除它
 		if _, err := os.Stat(filePath); err == nil {
 			return os.Remove(filePath)
@@ -717,8 +833,9 @@ func (m *Master) saveStateToPath(filePath string) error {
 	}
 
 	// 编码数据
-	encoder := gob.NewEncoder(tempFile)
-	if err := encoder.Encode(persistentData); err != nil {
+	encoder := json.NewEncoder(tempFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(state); err != nil {
 		tempFile.Close()
 		removeTemp()
 		return fmt.Errorf("saveStateToPath: encode failed: %w", err)
@@ -748,57 +865,24 @@ func (m *Master) loadState() {
 		}
 	}
 
-	// 检查文件是否存在
-	if _, err := os.Stat(m.statePath); os.IsNotExist(err) {
-		return
-	}
-
-	// 打开文件
-	file, err := os.Open(m.statePath)
+	// 读取并迁移状态文件
+	instances, err := m.readState()
 	if err != nil {
-		m.logger.Error("loadState: open file failed: %v", err)
+		m.logger.Error("loadState: %v", err)
 		return
 	}
-	defer file.Close()
-
-	// 解码数据
-	var persistentData map[string]*Instance
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&persistentData); err != nil {
-		m.logger.Error("loadState: decode file failed: %v", err)
+	if instances == nil {
 		return
 	}
 
 	// 恢复实例
-	for id, instance := range persistentData {
-		instance.stopped = make(chan struct{})
+	m.restoreState(instances)
+	m.logger.Info("Loaded %v instances from %v", len(instances), m.statePath)
 
-		// 重置实例状态
-		if instance.ID != apiKeyID {
-			instance.Status = "stopped"
-		}
-
-		// 生成完整配置
-		if instance.Config == "" && instance.ID != apiKeyID {
-			instance.Config = m.generateConfigURL(instance)
-		}
-
-		// 初始化标签映射
-		if instance.Meta.Tags == nil {
-			instance.Meta.Tags = make(map[string]string)
-		}
-
-		m.instances.Store(id, instance)
-
-		// 处理自启动
-		if instance.Restart {
-			m.logger.Info("Auto-starting instance: %v [%v]", instance.URL, instance.ID)
-			m.startInstance(instance)
-			time.Sleep(baseDuration)
-		}
+	// 以当前格式保存转换后的状态
+	if err := m.saveState(); err != nil {
+		m.logger.Error("loadState: save state failed: %v", err)
 	}
-
-	m.logger.Info("Loaded %v instances from %v", len(persistentData), m.statePath)
 }
 
 // handleOpenAPISpec 处理OpenAPI规范请求
@@ -822,6 +906,11 @@ func (m *Master) handleInfo(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusOK, m.getMasterInfo())
 
 	case http.MethodPost:
+		if requestKey(r).scoped() {
+			httpError(w, "Forbidden: scoped key", http.StatusForbidden)
+			return
+		}
+
 		var reqData struct {
 			Alias string `json:"alias"`
 		}
@@ -835,7 +924,9 @@ func (m *Master) handleInfo(w http.ResponseWriter, r *http.Request) {
 			httpError(w, fmt.Sprintf("Master alias exceeds maximum length %d", maxValueLen), http.StatusBadRequest)
 			return
 		}
+		auditTrack(r, "", map[string]any{"alias": m.alias})
 		m.alias = reqData.Alias
+		auditResult(r, map[string]any{"alias": m.alias})
 
 		// 持久化别名到API Key实例
 		if apiKey, ok := m.findInstance(apiKeyID); ok {
@@ -875,6 +966,7 @@ func (m *Master) getMasterInfo() map[string]any {
 		"tls":        m.tlsCode,
 		"crt":        m.crtPath,
 		"key":        m.keyPath,
+		"config":     m.declarePath,
 	}
 
 	if runtime.GOOS == "linux" {
@@ -1023,19 +1115,167 @@ func getLinuxSysInfo() SystemInfo {
 	return info
 }
 
+// handleMetrics 处理Prometheus指标请求
+func (m *Master) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(m.generateMetrics(requestKey(r))))
+}
+
+// generateMetrics 生成Prometheus文本格式指标
+func (m *Master) generateMetrics(key *APIKey) string {
+	var b strings.Builder
+
+	// 写入指标头部
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	// 主控指标
+	header("nodepass_master_info", "gauge", "NodePass master information.")
+	fmt.Fprintf(&b, "nodepass_master_info{mid=\"%s\",alias=\"%s\",version=\"%s\",os=\"%s\",arch=\"%s\"} 1\n",
+		escapeLabelValue(m.mid), escapeLabelValue(m.alias), escapeLabelValue(m.version), runtime.GOOS, runtime.GOARCH)
+	header("nodepass_master_uptime_seconds", "gauge", "Seconds since the master started.")
+	fmt.Fprintf(&b, "nodepass_master_uptime_seconds %d\n", uint64(time.Since(m.startTime).Seconds()))
+
+	// 系统指标
+	if runtime.GOOS == "linux" {
+		sysInfo := getLinuxSysInfo()
+		for _, metric := range []struct {
+			name, kind, help string
+			value            any
+		}{
+			{"nodepass_host_cpu_usage_percent", "gauge", "Host CPU usage in percent.", sysInfo.CPU},
+			{"nodepass_host_memory_total_bytes", "gauge", "Host memory capacity in bytes.", sysInfo.MemTotal},
+			{"nodepass_host_memory_used_bytes", "gauge", "Host memory used in bytes.", sysInfo.MemUsed},
+			{"nodepass_host_swap_total_bytes", "gauge", "Host swap capacity in bytes.", sysInfo.SwapTotal},
+			{"nodepass_host_swap_used_bytes", "gauge", "Host swap used in bytes.", sysInfo.SwapUsed},
+			{"nodepass_host_network_receive_bytes_total", "counter", "Host network bytes received.", sysInfo.NetRX},
+			{"nodepass_host_network_transmit_bytes_total", "counter", "Host network bytes transmitted.", sysInfo.NetTX},
+			{"nodepass_host_disk_read_bytes_total", "counter", "Host disk bytes read.", sysInfo.DiskR},
+			{"nodepass_host_disk_write_bytes_total", "counter", "Host disk bytes written.", sysInfo.DiskW},
+			{"nodepass_host_uptime_seconds", "gauge", "Host uptime in seconds.", sysInfo.SysUp},
+		} {
+			header(metric.name, metric.kind, metric.help)
+			fmt.Fprintf(&b, "%s %v\n", metric.name, metric.value)
+		}
+	}
+
+	// 收集实例并按ID排序
+	instances := []*Instance{}
+	m.instances.Range(func(_, value any) bool {
+		if instance := value.(*Instance); instance.ID != apiKeyID && key.canAccess(instance) {
+			instances = append(instances, instance)
+		}
+		return true
+	})
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+
+	// 构建实例标签
+	labels := make([]string, len(instances))
+	for i, instance := range instances {
+		var lb strings.Builder
+		fmt.Fprintf(&lb, "id=\"%s\",alias=\"%s\",type=\"%s\"",
+			escapeLabelValue(instance.ID), escapeLabelValue(instance.Alias), escapeLabelValue(instance.Type))
+		keys := make([]string, 0, len(instance.Meta.Tags))
+		for key := range instance.Meta.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		// 规范化后重名的标签仅保留首个
+		seen := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			name := sanitizeLabelName(key)
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			fmt.Fprintf(&lb, ",tag_%s=\"%s\"", name, escapeLabelValue(instance.Meta.Tags[key]))
+		}
+		labels[i] = lb.String()
+	}
+
+	// 实例状态指标
+	header("nodepass_instance_status", "gauge", "Instance status, 1 for the current status.")
+	for i, instance := range instances {
+		for _, status := range []string{"running", "stopped", "error"} {
+			value := 0
+			if instance.Status == status {
+				value = 1
+			}
+			fmt.Fprintf(&b, "nodepass_instance_status{%s,status=\"%s\"} %d\n", labels[i], status, value)
+		}
+	}
+
+	// 实例数值指标
+	for _, metric := range []struct {
+		name, kind, help string
+		value            func(*Instance) any
+	}{
+		{"nodepass_instance_mode", "gauge", "Instance run mode.", func(i *Instance) any { return i.Mode }},
+		{"nodepass_instance_ping_milliseconds", "gauge", "Instance tunnel latency in milliseconds.", func(i *Instance) any { return i.Ping }},
+		{"nodepass_instance_pool_connections", "gauge", "Instance tunnel pool connections.", func(i *Instance) any { return i.Pool }},
+		{"nodepass_instance_tcp_connections", "gauge", "Instance active TCP connections.", func(i *Instance) any { return i.TCPS }},
+		{"nodepass_instance_udp_connections", "gauge", "Instance active UDP sessions.", func(i *Instance) any { return i.UDPS }},
+		{"nodepass_instance_tcp_receive_bytes_total", "counter", "Instance TCP bytes received.", func(i *Instance) any { return i.TCPRX }},
+		{"nodepass_instance_tcp_transmit_bytes_total", "counter", "Instance TCP bytes transmitted.", func(i *Instance) any { return i.TCPTX }},
+		{"nodepass_instance_udp_receive_bytes_total", "counter", "Instance UDP bytes received.", func(i *Instance) any { return i.UDPRX }},
+		{"nodepass_instance_udp_transmit_bytes_total", "counter", "Instance UDP bytes transmitted.", func(i *Instance) any { return i.UDPTX }},
+		{"nodepass_instance_restarts_total", "counter", "Instance restarts since creation.", func(i *Instance) any { return i.Restarts }},
+		{"nodepass_instance_rejected_connections_total", "counter", "Connections rejected by access control or slot limits.", func(i *Instance) any { return i.Rejects }},
+	} {
+		header(metric.name, metric.kind, metric.help)
+		for i, instance := range instances {
+			fmt.Fprintf(&b, "%s{%s} %v\n", metric.name, labels[i], metric.value(instance))
+		}
+	}
+
+	return b.String()
+}
+
+// escapeLabelValue 转义Prometheus标签值
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// sanitizeLabelName 规范化Prometheus标签名
+func sanitizeLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
 // handleInstances 处理实例集合请求
 func (m *Master) handleInstances(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		// 获取所有实例
+		// 获取当前Key可访问的所有实例
+		key := requestKey(r)
 		instances := []*Instance{}
 		m.instances.Range(func(_, value any) bool {
-			instances = append(instances, value.(*Instance))
+			if instance := value.(*Instance); key.canAccess(instance) {
+				instances = append(instances, instance)
+			}
 			return true
 		})
 		writeJSON(w, http.StatusOK, instances)
 
 	case http.MethodPost:
+		// 限定范围的Key无法创建实例
+		if requestKey(r).scoped() {
+			httpError(w, "Forbidden: scoped key", http.StatusForbidden)
+			return
+		}
+
 		// 创建新实例
 		var reqData struct {
 			Alias string `json:"alias"`
@@ -1047,7 +1287,7 @@ func (m *Master) handleInstances(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 解析URL
-		parsedURL, err := url.Parse(reqData.URL)
+		parsedURL, err := ParseURL(reqData.URL)
 		if err != nil {
 			httpError(w, "Invalid URL format", http.StatusBadRequest)
 			return
@@ -1060,44 +1300,62 @@ func (m *Master) handleInstances(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 生成实例ID
-		id := generateID()
-		if _, exists := m.instances.Load(id); exists {
-			httpError(w, "Instance ID already exists", http.StatusConflict)
+		// 创建并启动实例
+		instance, err := m.createInstance(reqData.Alias, reqData.URL, instanceType, true, false, nil)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusConflict)
 			return
 		}
+		auditTrack(r, instance.ID, nil)
+		auditResult(r, auditSnapshot(instance))
+		writeJSON(w, http.StatusCreated, instance)
 
-		// 创建实例
-		instance := &Instance{
-			ID:      id,
-			Alias:   reqData.Alias,
-			Type:    instanceType,
-			URL:     m.enhanceURL(reqData.URL, instanceType),
-			Status:  "stopped",
-			Restart: true,
-			Meta:    Meta{Tags: make(map[string]string)},
-			stopped: make(chan struct{}),
-		}
-
-		instance.Config = m.generateConfigURL(instance)
-		m.instances.Store(id, instance)
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
 
-		// 启动实例
-		go m.startInstance(instance)
+// createInstance 创建并启动实例
+func (m *Master) createInstance(alias, rawURL, instanceType string, restart, managed bool, tags map[string]string) (*Instance, error) {
+	// 生成实例ID
+	id := generateID()
+	if _, exists := m.instances.Load(id); exists {
+		return nil, fmt.Errorf("Instance ID already exists")
+	}
+	if tags == nil {
+		tags = make(map[string]string)
+	}
 
-		// 保存实例状态
-		go func() {
-			time.Sleep(baseDuration)
-			m.saveState()
-		}()
-		writeJSON(w, http.StatusCreated, instance)
+	// 创建实例
+	instance := &Instance{
+		ID:      id,
+		Alias:   alias,
+		Type:    instanceType,
+		URL:     m.enhanceURL(rawURL, instanceType),
+		Status:  "stopped",
+		Restart: restart,
+		Managed: managed,
+		Meta:    Meta{Tags: tags},
+		stopped: make(chan struct{}),
+	}
 
-		// 发送创建事件
-		m.sendSSEEvent("create", instance)
+	instance.Config = m.generateConfigURL(instance)
+	m.instances.Store(id, instance)
 
-	default:
-		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// 仅启动自启动实例
+	if restart {
+		go m.startInstance(instance)
 	}
+
+	// 保存实例状态
+	go func() {
+		time.Sleep(baseDuration)
+		m.saveState()
+	}()
+
+	// 发送创建事件
+	m.sendSSEEvent("create", instance)
+	return instance, nil
 }
 
 // handleInstanceDetail 处理单个实例请求
@@ -1109,20 +1367,25 @@ func (m *Master) handleInstanceDetail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 查找实例
+	// 查找实例，超出Key范围时视为不存在
 	instance, ok := m.findInstance(id)
-	if !ok {
+	if !ok || !requestKey(r).canAccess(instance) {
 		httpError(w, "Instance not found", http.StatusNotFound)
 		return
 	}
 
+	// 记录变更前字段
+	auditTrack(r, id, auditSnapshot(instance))
+
 	switch r.Method {
 	case http.MethodGet:
 		m.handleGetInstance(w, instance)
 	case http.MethodPatch:
 		m.handlePatchInstance(w, r, id, instance)
+		auditResult(r, auditSnapshot(instance))
 	case http.MethodPut:
 		m.handlePutInstance(w, r, id, instance)
+		auditResult(r, auditSnapshot(instance))
 	case http.MethodDelete:
 		m.handleDeleteInstance(w, id, instance)
 	default:
@@ -1141,12 +1404,14 @@ func (m *Master) handlePatchInstance(w http.ResponseWriter, r *http.Request, id
 		Alias   string `json:"alias,omitempty"`
 		Action  string `json:"action,omitempty"`
 		Restart *bool  `json:"restart,omitempty"`
+		Quota   *Quota `json:"quota,omitempty"`
 		Meta    *struct {
 			Peer *Peer             `json:"peer,omitempty"`
 			Tags map[string]string `json:"tags,omitempty"`
 		} `json:"meta,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&reqData); err == nil {
+		auditAction(r, reqData.Action)
 		if id == apiKeyID {
 			// API Key实例只允许restart操作
 			if reqData.Action == "restart" {
@@ -1155,6 +1420,14 @@ func (m *Master) handlePatchInstance(w http.ResponseWriter, r *http.Request, id
 				m.sendSSEEvent("update", instance)
 			}
 		} else {
+			// 受管实例的别名、自启动和标签仅能通过配置文件修改
+			if instance.Managed && ((reqData.Alias != "" && instance.Alias != reqData.Alias) ||
+				(reqData.Restart != nil && instance.Restart != *reqData.Restart) ||
+				(reqData.Meta != nil && reqData.Meta.Tags != nil && !maps.Equal(instance.Meta.Tags, reqData.Meta.Tags))) {
+				httpError(w, "Instance managed by config file", http.StatusConflict)
+				return
+			}
+
 			// 更新实例别名
 			if reqData.Alias != "" && instance.Alias != reqData.Alias {
 				if len(reqData.Alias) > maxValueLen {
@@ -1198,6 +1471,7 @@ func (m *Master) handlePatchInstance(w http.ResponseWriter, r *http.Request, id
 					instance.TCPTXBase = 0
 					instance.UDPRXBase = 0
 					instance.UDPTXBase = 0
+					m.resetQuota(instance)
 					m.instances.Store(id, instance)
 					go m.saveState()
 					m.logger.Info("Traffic stats reset: 0 [%v]", instance.ID)
@@ -1206,7 +1480,10 @@ func (m *Master) handlePatchInstance(w http.ResponseWriter, r *http.Request, id
 					m.sendSSEEvent("update", instance)
 				} else {
 					// 处理 start/stop/restart 操作
-					m.processInstanceAction(instance, reqData.Action)
+					if err := m.processInstanceAction(instance, reqData.Action); err != nil {
+						httpError(w, err.Error(), http.StatusConflict)
+						return
+					}
 				}
 			}
 
@@ -1221,6 +1498,21 @@ func (m *Master) handlePatchInstance(w http.ResponseWriter, r *http.Request, id
 				m.sendSSEEvent("update", instance)
 			}
 
+			// 更新流量配额
+			if reqData.Quota != nil {
+				if err := reqData.Quota.validate(); err != nil {
+					httpError(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				m.updateQuota(instance, reqData.Quota)
+				m.instances.Store(id, instance)
+				go m.saveState()
+				m.logger.Info("Quota updated: %v [%v]", reqData.Quota.Limit, instance.ID)
+
+				// 发送配额变更事件
+				m.sendSSEEvent("update", instance)
+			}
+
 			// 更新元数据
 			if reqData.Meta != nil {
 				// 验证并更新 Peer 信息
@@ -1283,6 +1575,12 @@ func (m *Master) handlePutInstance(w http.ResponseWriter, r *http.Request, id st
 		return
 	}
 
+	// 受管实例仅能通过配置文件修改
+	if instance.Managed {
+		httpError(w, "Instance managed by config file", http.StatusConflict)
+		return
+	}
+
 	var reqData struct {
 		URL string `json:"url"`
 	}
@@ -1292,7 +1590,7 @@ func (m *Master) handlePutInstance(w http.ResponseWriter, r *http.Request, id st
 	}
 
 	// 解析URL
-	parsedURL, err := url.Parse(reqData.URL)
+	parsedURL, err := ParseURL(reqData.URL)
 	if err != nil {
 		httpError(w, "Invalid URL format", http.StatusBadRequest)
 		return
@@ -1314,6 +1612,24 @@ func (m *Master) handlePutInstance(w http.ResponseWriter, r *http.Request, id st
 		return
 	}
 
+	m.updateInstanceURL(instance, enhancedURL, instanceType)
+	writeJSON(w, http.StatusOK, instance)
+}
+
+// updateInstanceURL 更新实例URL，优先热重载，无法热重载时重启
+func (m *Master) updateInstanceURL(instance *Instance, enhancedURL, instanceType string) {
+	if instance.Status == "running" && instance.Type == instanceType {
+		applied, err := m.reloadInstance(instance, enhancedURL)
+		if err == nil {
+			instance.Config = m.generateConfigURL(instance)
+			m.instances.Store(instance.ID, instance)
+			go m.saveState()
+			m.logger.Info("Instance URL reloaded: %v [%v]", strings.Join(applied, ","), instance.ID)
+			return
+		}
+		m.logger.Info("Instance reload skipped: %v [%v]", err, instance.ID)
+	}
+
 	// 如果实例需要停止，先停止它
 	if instance.Status != "stopped" {
 		m.stopInstance(instance)
@@ -1327,7 +1643,7 @@ func (m *Master) handlePutInstance(w http.ResponseWriter, r *http.Request, id st
 
 	// 更新实例状态
 	instance.Status = "stopped"
-	m.instances.Store(id, instance)
+	m.instances.Store(instance.ID, instance)
 
 	// 启动实例
 	go m.startInstance(instance)
@@ -1337,11 +1653,61 @@ func (m *Master) handlePutInstance(w http.ResponseWriter, r *http.Request, id st
 		time.Sleep(baseDuration)
 		m.saveState()
 	}()
-	writeJSON(w, http.StatusOK, instance)
 
 	m.logger.Info("Instance URL updated: %v [%v]", instance.URL, instance.ID)
 }
 
+// reloadInstance 通过IPC通知运行中的实例按rawURL与当前配额热重载配置，rawURL为空时使用当前URL，成功后更新实例URL
+func (m *Master) reloadInstance(instance *Instance, rawURL string) ([]string, error) {
+	instance.ipcMu.Lock()
+	defer instance.ipcMu.Unlock()
+
+	ipcCmd, ipcReply := instance.ipcCmd, instance.ipcReply
+	if ipcCmd == nil || ipcReply == nil {
+		return nil, fmt.Errorf("reloadInstance: IPC unavailable")
+	}
+	if rawURL == "" {
+		rawURL = instance.URL
+	}
+
+	// 清理过期应答，避免占满通道导致本次应答被丢弃
+	select {
+	case <-ipcReply:
+	default:
+	}
+
+	instance.ipcSeq++
+	seq := instance.ipcSeq
+	data, err := json.Marshal(&IPCMessage{Type: ipcTypeCommand, Command: ipcCommandReload, Seq: seq, URL: m.quotaURL(instance, rawURL)})
+	if err != nil {
+		return nil, fmt.Errorf("reloadInstance: marshal failed: %w", err)
+	}
+	if _, err := ipcCmd.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("reloadInstance: write failed: %w", err)
+	}
+
+	timeout := time.After(reloadTimeout)
+	for {
+		select {
+		case reply := <-ipcReply:
+			// 丢弃超时后到达的过期应答
+			if reply.Seq != seq {
+				continue
+			}
+			if reply.Message != "" {
+				return nil, fmt.Errorf("reloadInstance: %v", reply.Message)
+			}
+			if len(reply.Pending) > 0 {
+				return nil, fmt.Errorf("reloadInstance: restart required for %v", strings.Join(reply.Pending, ","))
+			}
+			instance.URL = rawURL
+			return reply.Applied, nil
+		case <-timeout:
+			return nil, fmt.Errorf("reloadInstance: reply timeout")
+		}
+	}
+}
+
 // regenerateAPIKey 重新生成API Key
 func (m *Master) regenerateAPIKey(instance *Instance) {
 	instance.URL = generateAPIKey()
@@ -1352,23 +1718,37 @@ func (m *Master) regenerateAPIKey(instance *Instance) {
 }
 
 // processInstanceAction 处理实例操作
-func (m *Master) processInstanceAction(instance *Instance, action string) {
+func (m *Master) processInstanceAction(instance *Instance, action string) error {
 	switch action {
 	case "start":
+		if err := m.quotaBlocked(instance); err != nil {
+			return err
+		}
 		if instance.Status == "stopped" {
 			go m.startInstance(instance)
 		}
 	case "stop":
+		// 手动停止的实例在配额重置后不会自动启动
+		m.clearHalted(instance)
 		if instance.Status != "stopped" {
 			go m.stopInstance(instance)
 		}
 	case "restart":
-		go func() {
-			m.stopInstance(instance)
-			time.Sleep(baseDuration)
-			m.startInstance(instance)
-		}()
+		if err := m.quotaBlocked(instance); err != nil {
+			return err
+		}
+		go m.restartInstance(instance)
 	}
+	return nil
+}
+
+// restartInstance 停止并重新启动实例，计入重启次数
+func (m *Master) restartInstance(instance *Instance) {
+	m.stopInstance(instance)
+	time.Sleep(baseDuration)
+	instance.restarting = true
+	m.startInstance(instance)
+	instance.restarting = false
 }
 
 // handleDeleteInstance 处理删除实例请求
@@ -1379,17 +1759,28 @@ func (m *Master) handleDeleteInstance(w http.ResponseWriter, id string, instance
 		return
 	}
 
+	// 受管实例仅能通过配置文件删除
+	if instance.Managed {
+		httpError(w, "Instance managed by config file", http.StatusConflict)
+		return
+	}
+
+	m.deleteInstance(instance)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteInstance 停止并删除实例
+func (m *Master) deleteInstance(instance *Instance) {
 	// 标记实例为已删除
 	instance.deleted = true
-	m.instances.Store(id, instance)
+	m.instances.Store(instance.ID, instance)
 
 	if instance.Status != "stopped" {
 		m.stopInstance(instance)
 	}
-	m.instances.Delete(id)
+	m.instances.Delete(instance.ID)
 	// 删除实例后保存状态
 	go m.saveState()
-	w.WriteHeader(http.StatusNoContent)
 
 	// 发送删除事件
 	m.sendSSEEvent("delete", instance)
@@ -1415,16 +1806,24 @@ func (m *Master) handleSSE(w http.ResponseWriter, r *http.Request) {
 	// 创建一个通道用于接收事件
 	events := make(chan *InstanceEvent, 10)
 
-	// 注册订阅者
-	m.subscribers.Store(subscriberID, events)
+	// 注册订阅者，记录所用Key以便吊销时定向断开
+	key := requestKey(r)
+	subscriber := &sseSubscriber{events: events}
+	if key != nil {
+		subscriber.keyID = key.ID
+	}
+	m.subscribers.Store(subscriberID, subscriber)
 	defer m.subscribers.Delete(subscriberID)
 
 	// 发送初始重试间隔
 	fmt.Fprintf(w, "retry: %d\n\n", sseRetryTime)
 
-	// 获取当前所有实例并发送初始状态
+	// 获取当前Key可访问的所有实例并发送初始状态
 	m.instances.Range(func(_, value any) bool {
 		instance := value.(*Instance)
+		if !key.canAccess(instance) {
+			return true
+		}
 		event := &InstanceEvent{
 			Type:     "initial",
 			Time:     time.Now(),
@@ -1451,8 +1850,8 @@ func (m *Master) handleSSE(w http.ResponseWriter, r *http.Request) {
 		<-ctx.Done()
 		close(connectionClosed)
 		// 从映射表中移除并关闭通道
-		if ch, exists := m.subscribers.LoadAndDelete(subscriberID); exists {
-			close(ch.(chan *InstanceEvent))
+		if value, exists := m.subscribers.LoadAndDelete(subscriberID); exists {
+			close(value.(*sseSubscriber).events)
 		}
 	}()
 
@@ -1466,6 +1865,11 @@ func (m *Master) handleSSE(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			// 跳过超出Key范围的实例事件
+			if event.Instance != nil && !key.canAccess(event.Instance) {
+				continue
+			}
+
 			// 序列化事件数据
 			data, err := json.Marshal(event)
 			if err != nil {
@@ -1507,7 +1911,7 @@ func (m *Master) shutdownSSEConnections() {
 
 	// 发送shutdown通知并关闭通道
 	m.subscribers.Range(func(key, value any) bool {
-		ch := value.(chan *InstanceEvent)
+		ch := value.(*sseSubscriber).events
 		wg.Add(1)
 		go func(subscriberID any, eventChan chan *InstanceEvent) {
 			defer wg.Done()
@@ -1527,12 +1931,24 @@ func (m *Master) shutdownSSEConnections() {
 	wg.Wait()
 }
 
+// closeKeySSEConnections 关闭使用指定Key的SSE连接，不影响其他订阅者
+func (m *Master) closeKeySSEConnections(keyID string) {
+	m.subscribers.Range(func(key, value any) bool {
+		if subscriber := value.(*sseSubscriber); subscriber.keyID == keyID {
+			if _, exists := m.subscribers.LoadAndDelete(key); exists {
+				close(subscriber.events)
+			}
+		}
+		return true
+	})
+}
+
 // startEventDispatcher 启动事件分发器
 func (m *Master) startEventDispatcher() {
 	for event := range m.notifyChannel {
 		// 向所有订阅者分发事件
 		m.subscribers.Range(func(_, value any) bool {
-			eventChan := value.(chan *InstanceEvent)
+			eventChan := value.(*sseSubscriber).events
 			// 非阻塞方式发送事件
 			select {
 			case eventChan <- event:
@@ -1554,20 +1970,27 @@ func (m *Master) findInstance(id string) (*Instance, bool) {
 }
 
 // startInstance 启动实例
-func (m *Master) startInstance(instance *Instance) {
+func (m *Master) startInstance(instance *Instance) error {
 	// 获取最新实例状态
 	if value, exists := m.instances.Load(instance.ID); exists {
 		instance = value.(*Instance)
 		if instance.Status != "stopped" {
-			return
+			return nil
 		}
 	}
 
+	// 超额停止的实例不允许启动
+	if err := m.quotaBlocked(instance); err != nil {
+		m.logger.Warn("startInstance: %v [%v]", err, instance.ID)
+		return fmt.Errorf("startInstance: %w", err)
+	}
+
 	// 启动前，记录基线
 	instance.TCPRXBase = instance.TCPRX
 	instance.TCPTXBase = instance.TCPTX
 	instance.UDPRXBase = instance.UDPRX
 	instance.UDPTXBase = instance.UDPTX
+	instance.RejectsBase = instance.Rejects
 
 	// 获取可执行文件路径
 	execPath, err := os.Executable()
@@ -1576,17 +1999,37 @@ func (m *Master) startInstance(instance *Instance) {
 		instance.Status = "error"
 		m.instances.Store(instance.ID, instance)
 		m.sendSSEEvent("update", instance)
-		return
+		return fmt.Errorf("startInstance: get path failed: %w", err)
 	}
 
 	// 创建上下文和命令
 	ctx, cancel := context.WithCancel(context.Background())
-	cmd := exec.CommandContext(ctx, execPath, instance.URL)
+	cmd := exec.CommandContext(ctx, execPath, m.quotaURL(instance, instance.URL))
 	instance.cancelFunc = cancel
 
 	// 设置日志输出
 	writer := NewInstanceLogWriter(instance.ID, instance, os.Stdout, m)
 	cmd.Stdout, cmd.Stderr = writer, writer
+	cmd.Env = append(os.Environ(), instanceIDEnv+"="+instance.ID)
+
+	// 建立IPC通道，子进程通过继承的文件描述符上报状态并接收命令
+	var ipcFiles []*os.File
+	if runtime.GOOS != "windows" {
+		if ipcReader, ipcWriter, err := os.Pipe(); err == nil {
+			if cmdReader, cmdWriter, err := os.Pipe(); err == nil {
+				ipcFiles = []*os.File{ipcReader, ipcWriter, cmdReader, cmdWriter}
+				cmd.ExtraFiles = []*os.File{ipcWriter, cmdReader}
+				cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", ipcFDEnv, 3), fmt.Sprintf("%s=%d", ipcCmdFDEnv, 4))
+				writer.ipc = true
+			} else {
+				ipcReader.Close()
+				ipcWriter.Close()
+				m.logger.Warn("startInstance: create IPC pipe failed: %v [%v]", err, instance.ID)
+			}
+		} else {
+			m.logger.Warn("startInstance: create IPC pipe failed: %v [%v]", err, instance.ID)
+		}
+	}
 
 	m.logger.Info("Instance starting: %v [%v]", instance.URL, instance.ID)
 
@@ -1601,7 +2044,25 @@ func (m *Master) startInstance(instance *Instance) {
 		m.instances.Store(instance.ID, instance)
 		m.sendSSEEvent("update", instance)
 		cancel()
-		return
+		for _, file := range ipcFiles {
+			file.Close()
+		}
+		return fmt.Errorf("startInstance: instance start failed")
+	}
+
+	// 关闭父进程中的子进程端并读取IPC消息
+	if ipcFiles != nil {
+		ipcFiles[1].Close()
+		ipcFiles[2].Close()
+		instance.ipcCmd = ipcFiles[3]
+		instance.ipcReply = make(chan *IPCMessage, 1)
+		go writer.readIPC(ipcFiles[0], ipcFiles[3])
+	}
+
+	// 统计重启次数
+	if instance.restarting {
+		instance.Restarts++
+		instance.restarting = false
 	}
 
 	instance.cmd = cmd
@@ -1612,6 +2073,7 @@ func (m *Master) startInstance(instance *Instance) {
 
 	// 发送启动事件
 	m.sendSSEEvent("update", instance)
+	return nil
 }
 
 // monitorInstance 监控实例状态
@@ -1718,7 +2180,7 @@ func (m *Master) stopInstance(instance *Instance) {
 
 // enhanceURL 增强URL，添加日志级别和TLS配置
 func (m *Master) enhanceURL(instanceURL string, instanceType string) string {
-	parsedURL, err := url.Parse(instanceURL)
+	parsedURL, err := ParseURL(instanceURL)
 	if err != nil {
 		m.logger.Error("enhanceURL: invalid URL format: %v", err)
 		return instanceURL
@@ -1754,7 +2216,7 @@ func (m *Master) enhanceURL(instanceURL string, instanceType string) string {
 
 // generateConfigURL 生成实例的完整URL
 func (m *Master) generateConfigURL(instance *Instance) string {
-	parsedURL, err := url.Parse(instance.URL)
+	parsedURL, err := ParseURL(instance.URL)
 	if err != nil {
 		m.logger.Error("generateConfigURL: invalid URL format: %v", err)
 		return instance.URL
@@ -1787,7 +2249,7 @@ func (m *Master) generateConfigURL(instance *Instance) string {
 	// 根据实例类型设置默认参数
 	switch instance.Type {
 	case "client":
-		// client参数: dns, sni, min, mode, dial, read, rate, slot, proxy, block, notcp, noudp
+		// client参数: dns, sni, min, mode, dial, read, rate, slot, proxy, block, notcp, noudp, lb
 		if query.Get("dns") == "" {
 			query.Set("dns", defaultDNSTTL.String())
 		}
@@ -1824,8 +2286,11 @@ func (m *Master) generateConfigURL(instance *Instance) string {
 		if query.Get("noudp") == "" {
 			query.Set("noudp", defaultUDPStrategy)
 		}
+		if query.Get("lb") == "" {
+			query.Set("lb", defaultLBStrategy)
+		}
 	case "server":
-		// server参数: dns, max, mode, type, dial, read, rate, slot, proxy, block, notcp, noudp
+		// server参数: dns, max, mode, type, dial, read, rate, slot, proxy, block, notcp, noudp, lb
 		if query.Get("dns") == "" {
 			query.Set("dns", defaultDNSTTL.String())
 		}
@@ -1862,6 +2327,9 @@ func (m *Master) generateConfigURL(instance *Instance) string {
 		if query.Get("noudp") == "" {
 			query.Set("noudp", defaultUDPStrategy)
 		}
+		if query.Get("lb") == "" {
+			query.Set("lb", defaultLBStrategy)
+		}
 	}
 
 	parsedURL.RawQuery = query.Encode()
@@ -2046,6 +2514,101 @@ func (m *Master) generateOpenAPISpec() string {
 		}
 	  }
 	},
+	"/metrics": {
+	  "get": {
+		"summary": "Get Prometheus metrics",
+		"security": [{"ApiKeyAuth": []}],
+		"responses": {
+		  "200": {"description": "Success", "content": {"text/plain": {}}},
+		  "401": {"description": "Unauthorized"},
+		  "405": {"description": "Method not allowed"}
+		}
+	  }
+	},
+	"/keys": {
+	  "get": {
+		"summary": "List API keys",
+		"security": [{"ApiKeyAuth": []}],
+		"responses": {
+		  "200": {"description": "Success", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/APIKey"}}}}},
+		  "401": {"description": "Unauthorized"},
+		  "403": {"description": "Forbidden"},
+		  "405": {"description": "Method not allowed"}
+		}
+	  },
+	  "post": {
+		"summary": "Create API key",
+		"security": [{"ApiKeyAuth": []}],
+		"requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CreateKeyRequest"}}}},
+		"responses": {
+		  "201": {"description": "Created, the key is only returned once", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/APIKey"}}}},
+		  "400": {"description": "Invalid input"},
+		  "401": {"description": "Unauthorized"},
+		  "403": {"description": "Forbidden"},
+		  "405": {"description": "Method not allowed"}
+		}
+	  }
+	},
+	"/keys/{id}": {
+	  "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+	  "delete": {
+		"summary": "Revoke API key",
+		"security": [{"ApiKeyAuth": []}],
+		"responses": {
+		  "204": {"description": "Revoked"},
+		  "401": {"description": "Unauthorized"},
+		  "403": {"description": "Forbidden"},
+		  "404": {"description": "Not found"},
+		  "405": {"description": "Method not allowed"}
+		}
+	  }
+	},
+	"/state/export": {
+	  "get": {
+		"summary": "Export master state",
+		"security": [{"ApiKeyAuth": []}],
+		"responses": {
+		  "200": {"description": "Success", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StateFile"}}}},
+		  "401": {"description": "Unauthorized"},
+		  "403": {"description": "Forbidden"},
+		  "405": {"description": "Method not allowed"}
+		}
+	  }
+	},
+	"/state/import": {
+	  "post": {
+		"summary": "Import master state, replacing all instances",
+		"security": [{"ApiKeyAuth": []}],
+		"requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/StateFile"}}}},
+		"responses": {
+		  "200": {"description": "Imported", "content": {"application/json": {"schema": {"type": "object", "properties": {"version": {"type": "integer"}, "instances": {"type": "integer"}}}}}},
+		  "400": {"description": "Invalid state"},
+		  "401": {"description": "Unauthorized"},
+		  "403": {"description": "Forbidden"},
+		  "405": {"description": "Method not allowed"}
+		}
+	  }
+	},
+	"/audit": {
+	  "get": {
+		"summary": "Query audit log",
+		"security": [{"ApiKeyAuth": []}],
+		"parameters": [
+		  {"name": "instance", "in": "query", "schema": {"type": "string"}, "description": "Filter by instance ID"},
+		  {"name": "key", "in": "query", "schema": {"type": "string"}, "description": "Filter by key ID"},
+		  {"name": "since", "in": "query", "schema": {"type": "string", "format": "date-time"}, "description": "Entries at or after this time"},
+		  {"name": "until", "in": "query", "schema": {"type": "string", "format": "date-time"}, "description": "Entries at or before this time"},
+		  {"name": "limit", "in": "query", "schema": {"type": "integer", "default": 100, "maximum": 1000}, "description": "Most recent entries to return"}
+		],
+		"responses": {
+		  "200": {"description": "Success", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/AuditEntry"}}}}},
+		  "400": {"description": "Invalid query"},
+		  "401": {"description": "Unauthorized"},
+		  "403": {"description": "Forbidden"},
+		  "405": {"description": "Method not allowed"}
+		}
+	  }
+	},
 	"/openapi.json": {
 	  "get": {
 		"summary": "Get OpenAPI specification",
@@ -2083,6 +2646,7 @@ func (m *Master) generateOpenAPISpec() string {
 	  "url": {"type": "string", "description": "Command string or API Key"},
 	  "config": {"type": "string", "description": "Instance configuration URL"},
 	  "restart": {"type": "boolean", "description": "Restart policy"},
+	  "managed": {"type": "boolean", "description": "Managed by the declarative config file"},
 	  "meta": {"$ref": "#/components/schemas/Meta"},
 	  "mode": {"type": "integer", "description": "Instance mode"},
 	  "ping": {"type": "integer", "description": "TCPing latency"},
@@ -2092,7 +2656,10 @@ func (m *Master) generateOpenAPISpec() string {
 	  "tcprx": {"type": "integer", "description": "TCP received bytes"},
 	  "tcptx": {"type": "integer", "description": "TCP transmitted bytes"},
 	  "udprx": {"type": "integer", "description": "UDP received bytes"},
-	  "udptx": {"type": "integer", "description": "UDP transmitted bytes"}
+	  "udptx": {"type": "integer", "description": "UDP transmitted bytes"},
+	  "restarts": {"type": "integer", "description": "Restart count"},
+	  "rejects": {"type": "integer", "description": "Connections rejected by source access control"},
+	  "quota": {"$ref": "#/components/schemas/Quota"}
 	}
 	 },
 	  "CreateInstanceRequest": {
@@ -2109,9 +2676,24 @@ func (m *Master) generateOpenAPISpec() string {
 		  "alias": {"type": "string", "description": "Instance alias"},
 		  "action": {"type": "string", "enum": ["start", "stop", "restart", "reset"], "description": "Action for the instance"},
 		  "restart": {"type": "boolean", "description": "Instance restart policy"},
+		  "quota": {"$ref": "#/components/schemas/Quota"},
 		  "meta": {"$ref": "#/components/schemas/Meta"}
 		}
 	  },
+	  "Quota": {
+		"type": "object",
+		"properties": {
+		  "limit": {"type": "integer", "format": "int64", "description": "Traffic limit in bytes, 0 removes the quota"},
+		  "cycle": {"type": "string", "enum": ["", "daily", "monthly"], "description": "Automatic reset cycle"},
+		  "day": {"type": "integer", "description": "Day of month for monthly reset (1-28)"},
+		  "hour": {"type": "integer", "description": "Hour of reset (0-23)"},
+		  "action": {"type": "string", "enum": ["event", "stop", "throttle"], "description": "Action when exceeded"},
+		  "throttle": {"type": "integer", "description": "Rate in Mbps when throttled"},
+		  "used": {"type": "integer", "format": "int64", "description": "Bytes used in current cycle", "readOnly": true},
+		  "exceeded": {"type": "boolean", "description": "Whether the quota is exceeded", "readOnly": true},
+		  "reset_at": {"type": "string", "format": "date-time", "description": "Next reset time", "readOnly": true}
+		}
+	  },
 	  "PutInstanceRequest": {
 		"type": "object",
 		"required": ["url"],
@@ -2158,6 +2740,58 @@ func (m *Master) generateOpenAPISpec() string {
 		  "key": {"type": "string", "description": "Private key path"}
 		}
 	  },
+	  "StateFile": {
+		"type": "object",
+		"required": ["version", "instances"],
+		"properties": {
+		  "version": {"type": "integer", "description": "State file version"},
+		  "saved": {"type": "string", "format": "date-time", "description": "Save time"},
+		  "instances": {"type": "object", "additionalProperties": {"type": "object"}, "description": "Persisted instances by ID"}
+		}
+	  },
+	  "AuditEntry": {
+		"type": "object",
+		"properties": {
+		  "time": {"type": "string", "format": "date-time", "description": "Request time"},
+		  "key_id": {"type": "string", "description": "Key ID, ******** for the master key"},
+		  "key_name": {"type": "string", "description": "Key name"},
+		  "source": {"type": "string", "description": "Source IP"},
+		  "method": {"type": "string", "description": "HTTP method"},
+		  "endpoint": {"type": "string", "description": "Request path"},
+		  "instance": {"type": "string", "description": "Instance ID"},
+		  "action": {"type": "string", "description": "Instance action"},
+		  "status": {"type": "integer", "description": "Response status code"},
+		  "before": {"type": "object", "description": "Changed fields before the request, secrets redacted"},
+		  "after": {"type": "object", "description": "Changed fields after the request, secrets redacted"}
+		}
+	  },
+	  "APIKey": {
+		"type": "object",
+		"properties": {
+		  "id": {"type": "string", "description": "Key ID"},
+		  "name": {"type": "string", "description": "Key name"},
+		  "role": {"type": "string", "enum": ["read", "operator", "admin"], "description": "Key role"},
+		  "scope": {"$ref": "#/components/schemas/KeyScope"},
+		  "created": {"type": "string", "format": "date-time", "description": "Creation time"},
+		  "key": {"type": "string", "description": "Key secret, only returned on creation"}
+		}
+	  },
+	  "KeyScope": {
+		"type": "object",
+		"properties": {
+		  "ids": {"type": "array", "items": {"type": "string"}, "description": "Instance IDs"},
+		  "tags": {"type": "object", "additionalProperties": {"type": "string"}, "description": "Instance tags"}
+		}
+	  },
+	  "CreateKeyRequest": {
+		"type": "object",
+		"required": ["name", "role"],
+		"properties": {
+		  "name": {"type": "string", "description": "Key name"},
+		  "role": {"type": "string", "enum": ["read", "operator", "admin"], "description": "Key role"},
+		  "scope": {"$ref": "#/components/schemas/KeyScope"}
+		}
+	  },
 	  "UpdateMasterAliasRequest": {
 		"type": "object",
 		"required": ["alias"],
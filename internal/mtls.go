@@ -0,0 +1,129 @@
+// 内部包，实现双向TLS认证与客户端自行TLS握手
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RequireClientCert 为服务端TLS配置启用客户端证书校验，可限定证书主体
+func RequireClientCert(config *tls.Config, caFile, subjects string) error {
+	if caFile == "" {
+		return fmt.Errorf("requireClientCert: missing ca bundle")
+	}
+	clientCAs, err := loadCertPool(caFile)
+	if err != nil {
+		return fmt.Errorf("requireClientCert: %w", err)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = clientCAs
+
+	// 按证书主体放行
+	var allowed []string
+	for subject := range strings.SplitSeq(subjects, ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			allowed = append(allowed, subject)
+		}
+	}
+	if len(allowed) > 0 {
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("verifyConnection: no client certificate")
+			}
+			leaf := state.PeerCertificates[0]
+			names := append([]string{leaf.Subject.CommonName}, leaf.DNSNames...)
+			names = append(names, leaf.EmailAddresses...)
+			for _, uri := range leaf.URIs {
+				names = append(names, uri.String())
+			}
+			for _, name := range names {
+				if slices.Contains(allowed, name) {
+					return nil
+				}
+			}
+			return fmt.Errorf("verifyConnection: client subject not allowed: %v", leaf.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// loadCertPool 加载PEM格式的CA证书包
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("loadCertPool: %w", err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("loadCertPool: no certificates in %v", caFile)
+	}
+	return certPool, nil
+}
+
+// getClientTLS 获取客户端证书与服务端校验配置，均未设置时跳过
+func (c *Common) getClientTLS() error {
+	query := c.parsedURL.Query()
+	crtFile, keyFile, caFile := query.Get("crt"), query.Get("key"), query.Get("ca")
+	pins, err := parseCertPins(query.Get("pin"))
+	if err != nil {
+		return fmt.Errorf("getClientTLS: %w", err)
+	}
+	if crtFile == "" && keyFile == "" && caFile == "" && len(pins) == 0 {
+		return nil
+	}
+
+	config := &tls.Config{
+		ServerName: c.serverName,
+		MinVersion: tls.VersionTLS13,
+	}
+	if crtFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(crtFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("getClientTLS: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		if config.RootCAs, err = loadCertPool(caFile); err != nil {
+			return fmt.Errorf("getClientTLS: %w", err)
+		}
+	}
+
+	// 仅设置指纹时跳过证书链校验
+	if len(pins) > 0 {
+		config.InsecureSkipVerify = caFile == ""
+		config.VerifyConnection = verifyCertPin(pins)
+	}
+	c.clientTLS = config
+	c.verifyServer = caFile != "" || len(pins) > 0
+	return nil
+}
+
+// dialTunnelTLS 以客户端TLS配置建立隧道连接
+func (c *Common) dialTunnelTLS(address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: tcpDialTimeout, KeepAlive: reportInterval}
+	rawConn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("dialTunnelTLS: %w", err)
+	}
+	tlsConn := tls.Client(rawConn, c.clientTLS)
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		rawConn.Close()
+		return nil, fmt.Errorf("dialTunnelTLS: handshake failed: %w", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	// 记录服务端证书指纹供指纹验证使用
+	if state := tlsConn.ConnectionState(); len(state.PeerCertificates) > 0 {
+		c.tunnelFP.Store(c.formatCertFingerprint(state.PeerCertificates[0].Raw))
+	}
+
+	return tlsConn, nil
+}
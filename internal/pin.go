@@ -0,0 +1,101 @@
+// 内部包，实现客户端侧服务端证书校验
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// errCertVerified 探测握手在证书校验通过后主动中止
+var errCertVerified = errors.New("certificate verified")
+
+// parseCertPins 解析证书指纹，接受formatCertFingerprint格式或不含分隔符的十六进制
+func parseCertPins(value string) ([]string, error) {
+	var pins []string
+	for entry := range strings.SplitSeq(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		hexPin := strings.ToLower(entry)
+		hexPin = strings.TrimPrefix(hexPin, "sha256:")
+		hexPin = strings.ReplaceAll(hexPin, ":", "")
+		if raw, err := hex.DecodeString(hexPin); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("parseCertPins: invalid fingerprint: %v", entry)
+		}
+		pins = append(pins, hexPin)
+	}
+	return pins, nil
+}
+
+// verifyCertPin 校验服务端叶证书是否匹配任一指纹
+func verifyCertPin(pins []string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("verifyCertPin: no server certificate")
+		}
+		hash := sha256.Sum256(state.PeerCertificates[0].Raw)
+		if !slices.Contains(pins, hex.EncodeToString(hash[:])) {
+			return fmt.Errorf("verifyCertPin: server certificate not pinned")
+		}
+		return nil
+	}
+}
+
+// selfTLS 判断客户端是否自行完成隧道TLS握手
+func (c *Common) selfTLS() bool {
+	return c.tlsCode == "3" || c.verifyServer && (c.tlsCode == "1" || c.tlsCode == "2")
+}
+
+// probeTunnelCert 在建立连接池前校验服务端证书，校验通过即中止握手，不进入服务端连接池
+func (c *Common) probeTunnelCert() error {
+	tcpAddr, err := c.getTunnelTCPAddr()
+	if err != nil {
+		return fmt.Errorf("probeTunnelCert: %w", err)
+	}
+	rawConn, err := net.DialTimeout("tcp", tcpAddr.String(), tcpDialTimeout)
+	if err != nil {
+		return fmt.Errorf("probeTunnelCert: %w", err)
+	}
+	defer rawConn.Close()
+
+	config := c.clientTLS.Clone()
+	verify := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+		c.logger.Info("Tunnel certificate verified: %v", c.formatCertFingerprint(state.PeerCertificates[0].Raw))
+		return errCertVerified
+	}
+
+	tlsConn := tls.Client(rawConn, config)
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); !errors.Is(err, errCertVerified) {
+		return fmt.Errorf("probeTunnelCert: certificate verification failed: %w", err)
+	}
+	return nil
+}
+
+// peerFingerprint 获取隧道连接的服务端证书指纹，桥接连接使用自行握手时记录的指纹
+func (c *Common) peerFingerprint(testConn net.Conn) (string, bool) {
+	if conn, ok := testConn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		state := conn.ConnectionState()
+		if len(state.PeerCertificates) == 0 {
+			return "", true
+		}
+		return c.formatCertFingerprint(state.PeerCertificates[0].Raw), true
+	}
+	if fingerprint, ok := c.tunnelFP.Load().(string); ok {
+		return fingerprint, true
+	}
+	return "", false
+}
@@ -0,0 +1,135 @@
+// 内部包，实现端口范围转发
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/NodePassProject/conn"
+)
+
+// 端口范围常量
+const maxPortSpan = 16384 // 端口范围最大端口数
+
+// rangePort 端口范围内首端口之外的监听端口
+type rangePort struct {
+	route          string           // 目标映射名称
+	offset         int              // 端口范围偏移
+	targetListener *net.TCPListener // 目标监听器
+	targetUDPConn  *conn.StatConn   // 目标UDP连接
+}
+
+// splitPortRange 拆分端口范围，格式为host:start-end，返回首端口地址与端口数
+func splitPortRange(addr string) (string, int, error) {
+	host, ports, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 1, nil
+	}
+	startStr, endStr, ok := strings.Cut(ports, "-")
+	if !ok {
+		return addr, 1, nil
+	}
+	start, startErr := strconv.Atoi(startStr)
+	end, endErr := strconv.Atoi(endStr)
+	if startErr != nil || endErr != nil || start < 1 || end > 65535 || end < start || end-start+1 > maxPortSpan {
+		return "", 0, fmt.Errorf("splitPortRange: invalid port range for %s", addr)
+	}
+	return net.JoinHostPort(host, startStr), end - start + 1, nil
+}
+
+// ParseURL 解析实例URL，允许单端模式的主机端口写作端口范围start-end
+func ParseURL(rawURL string) (*url.URL, error) {
+	parsedURL, err := url.Parse(rawURL)
+	if err == nil {
+		return parsedURL, nil
+	}
+
+	// 以首端口替换主机端口范围后重新解析，再恢复原始主机
+	scheme, rest, ok := strings.Cut(rawURL, "://")
+	if !ok {
+		return nil, err
+	}
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	userInfo, host := "", rest[:end]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		userInfo, host = host[:at+1], host[at+1:]
+	}
+	first, _, rangeErr := splitPortRange(host)
+	if rangeErr != nil || first == host {
+		return nil, err
+	}
+	parsedURL, err = url.Parse(scheme + "://" + userInfo + first + rest[end:])
+	if err != nil {
+		return nil, err
+	}
+	parsedURL.Host = host
+	return parsedURL, nil
+}
+
+// offsetAddr 计算端口范围内指定偏移的地址
+func offsetAddr(addr any, offset int) string {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return (&net.TCPAddr{IP: addr.IP, Port: addr.Port + offset, Zone: addr.Zone}).String()
+	case *net.UDPAddr:
+		return (&net.UDPAddr{IP: addr.IP, Port: addr.Port + offset, Zone: addr.Zone}).String()
+	}
+	return ""
+}
+
+// targetSessionKey 生成区分映射与端口的UDP会话键
+func targetSessionKey(route string, offset int, addr string) string {
+	if route == "" && offset == 0 {
+		return addr
+	}
+	return route + "#" + strconv.Itoa(offset) + "/" + addr
+}
+
+// initPortRange 监听端口范围内首端口之外的其余端口
+func (c *Common) initPortRange(tcpAddr *net.TCPAddr, udpAddr *net.UDPAddr, span int, route string) error {
+	for offset := 1; offset < span; offset++ {
+		port := &rangePort{route: route, offset: offset}
+		c.rangePorts = append(c.rangePorts, port)
+
+		// 初始化范围TCP监听器
+		if tcpAddr != nil && c.disableTCP != "1" {
+			listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: tcpAddr.IP, Port: tcpAddr.Port + offset, Zone: tcpAddr.Zone})
+			if err != nil {
+				return fmt.Errorf("initPortRange: listenTCP failed: %w", err)
+			}
+			port.targetListener = listener
+		}
+
+		// 初始化范围UDP监听器
+		if udpAddr != nil && c.disableUDP != "1" {
+			udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: udpAddr.IP, Port: udpAddr.Port + offset, Zone: udpAddr.Zone})
+			if err != nil {
+				return fmt.Errorf("initPortRange: listenUDP failed: %w", err)
+			}
+			port.targetUDPConn = &conn.StatConn{Conn: udpConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
+		}
+	}
+	return nil
+}
+
+// closePortRange 关闭端口范围监听器
+func (c *Common) closePortRange() {
+	for _, port := range c.rangePorts {
+		if port.targetUDPConn != nil {
+			port.targetUDPConn.Close()
+		}
+		if port.targetListener != nil {
+			port.targetListener.Close()
+		}
+	}
+	if len(c.rangePorts) > 0 {
+		c.logger.Debug("Port range closed: %v ports", len(c.rangePorts))
+	}
+	c.rangePorts = nil
+}
@@ -0,0 +1,383 @@
+// 内部包，实现PROXY协议头部
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 代理协议版本
+const (
+	proxyV1 = "1" // 文本格式
+	proxyV2 = "2" // 二进制格式
+)
+
+// PROXY v2 常量
+const (
+	proxyV2Local    = 0x20 // 版本2，LOCAL命令
+	proxyV2Command  = 0x21 // 版本2，PROXY命令
+	proxyV2TCP4     = 0x11 // IPv4流式
+	proxyV2UDP4     = 0x12 // IPv4数据报
+	proxyV2TCP6     = 0x21 // IPv6流式
+	proxyV2UDP6     = 0x22 // IPv6数据报
+	proxyTLVAuth    = 0x02 // TLV：服务器名称
+	proxyTLVUID     = 0x05 // TLV：连接唯一ID
+	proxyTLVInst    = 0xE0 // TLV：实例ID
+	proxyTLVMaxSize = 128  // 唯一ID最大长度
+	proxyV1MaxSize  = 107  // v1头部最大长度
+)
+
+// proxyV2Signature PROXY v2 签名
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// proxyAddrPort 提取地址中的IP与端口
+func proxyAddrPort(addr net.Addr) (netip.AddrPort, error) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.AddrPort(), nil
+	case *net.UDPAddr:
+		return a.AddrPort(), nil
+	}
+	return netip.ParseAddrPort(addr.String())
+}
+
+// proxyAddrPair 统一源与目标地址族，混合时映射为IPv6
+func proxyAddrPair(source string, dest net.Addr) (netip.AddrPort, netip.AddrPort, bool, error) {
+	src, err := netip.ParseAddrPort(source)
+	if err != nil {
+		return src, src, false, fmt.Errorf("proxyAddrPair: parse source failed: %w", err)
+	}
+	dst, err := proxyAddrPort(dest)
+	if err != nil {
+		return src, dst, false, fmt.Errorf("proxyAddrPair: parse destination failed: %w", err)
+	}
+
+	srcIP, dstIP := src.Addr().Unmap().WithZone(""), dst.Addr().Unmap().WithZone("")
+	if srcIP.Is4() && dstIP.Is4() {
+		return netip.AddrPortFrom(srcIP, src.Port()), netip.AddrPortFrom(dstIP, dst.Port()), true, nil
+	}
+	return netip.AddrPortFrom(netip.AddrFrom16(srcIP.As16()), src.Port()),
+		netip.AddrPortFrom(netip.AddrFrom16(dstIP.As16()), dst.Port()), false, nil
+}
+
+// buildProxyV1Header 构建PROXY v1 头部
+func buildProxyV1Header(source string, dest net.Addr) ([]byte, error) {
+	src, dst, is4, err := proxyAddrPair(source, dest)
+	if err != nil {
+		return nil, fmt.Errorf("buildProxyV1Header: %w", err)
+	}
+
+	protocol := "TCP6"
+	if is4 {
+		protocol = "TCP4"
+	}
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n",
+		protocol, src.Addr(), dst.Addr(), src.Port(), dst.Port()), nil
+}
+
+// buildProxyV2Header 构建PROXY v2 头部，附带连接ID、实例ID与服务器名称
+func buildProxyV2Header(source string, dest net.Addr, datagram bool, id, serverName string) ([]byte, error) {
+	src, dst, is4, err := proxyAddrPair(source, dest)
+	if err != nil {
+		return nil, fmt.Errorf("buildProxyV2Header: %w", err)
+	}
+
+	// 地址族与传输协议
+	var family byte
+	switch {
+	case is4 && !datagram:
+		family = proxyV2TCP4
+	case is4 && datagram:
+		family = proxyV2UDP4
+	case !datagram:
+		family = proxyV2TCP6
+	default:
+		family = proxyV2UDP6
+	}
+
+	// 地址块
+	body := make([]byte, 0, 64)
+	body = append(body, src.Addr().AsSlice()...)
+	body = append(body, dst.Addr().AsSlice()...)
+	body = binary.BigEndian.AppendUint16(body, src.Port())
+	body = binary.BigEndian.AppendUint16(body, dst.Port())
+
+	// TLV扩展
+	if serverName != "" {
+		body = appendProxyTLV(body, proxyTLVAuth, serverName)
+	}
+	if id != "" && len(id) <= proxyTLVMaxSize {
+		body = appendProxyTLV(body, proxyTLVUID, id)
+	}
+	if instanceID := os.Getenv(instanceIDEnv); instanceID != "" {
+		body = appendProxyTLV(body, proxyTLVInst, instanceID)
+	}
+
+	header := make([]byte, 0, len(proxyV2Signature)+4+len(body))
+	header = append(header, proxyV2Signature...)
+	header = append(header, proxyV2Command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...), nil
+}
+
+// appendProxyTLV 追加单个TLV
+func appendProxyTLV(b []byte, typ byte, value string) []byte {
+	b = append(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+// sendProxyHeader 向TCP目标发送PROXY头部
+func (c *Common) sendProxyHeader(source string, conn net.Conn, id, serverName string) error {
+	var header []byte
+	var err error
+	switch c.proxyProtocol {
+	case proxyV1:
+		header, err = buildProxyV1Header(source, conn.RemoteAddr())
+	case proxyV2:
+		header, err = buildProxyV2Header(source, conn.RemoteAddr(), false, id, serverName)
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("sendProxyHeader: %w", err)
+	}
+
+	if _, err := conn.Write(header); err != nil {
+		return fmt.Errorf("sendProxyHeader: write failed: %w", err)
+	}
+	return nil
+}
+
+// wrapProxyDatagram 为UDP目标会话附加PROXY v2 数据报头部
+func (c *Common) wrapProxyDatagram(source string, conn net.Conn, id string) (net.Conn, error) {
+	if c.proxyProtocol != proxyV2 {
+		return conn, nil
+	}
+
+	header, err := buildProxyV2Header(source, conn.RemoteAddr(), true, id, "")
+	if err != nil {
+		return nil, fmt.Errorf("wrapProxyDatagram: %w", err)
+	}
+	return &proxyDatagramConn{Conn: conn, header: header}, nil
+}
+
+// proxyDatagramConn 每个数据报前附加PROXY v2 头部的UDP连接
+type proxyDatagramConn struct {
+	net.Conn
+	header []byte     // PROXY v2 头部
+	buffer []byte     // 写入缓冲区
+	mu     sync.Mutex // 写入互斥锁
+}
+
+// Write 写入带头部的数据报，返回负载长度
+func (pc *proxyDatagramConn) Write(b []byte) (int, error) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.buffer = append(append(pc.buffer[:0], pc.header...), b...)
+	if _, err := pc.Conn.Write(pc.buffer); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// errServerNameFound 已获取服务器名称
+var errServerNameFound = errors.New("server name found")
+
+// peekServerName 预读TLS ClientHello获取服务器名称，不消耗连接数据
+func (c *Common) peekServerName(conn net.Conn, deadline time.Time) (string, net.Conn) {
+	buffer := make([]byte, 5+16384)
+	n, need := 0, 5
+
+	// 按调用方给定的截止时间等待，避免服务端先发的协议阻塞
+	conn.SetReadDeadline(deadline)
+	for n < need {
+		x, err := conn.Read(buffer[n:])
+		n += x
+		if err != nil {
+			break
+		}
+		if need == 5 && n >= 5 {
+			if buffer[0] != 0x16 {
+				break
+			}
+			need = 5 + int(binary.BigEndian.Uint16(buffer[3:5]))
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	record := buffer[:n]
+	wrapped := &readerConn{Conn: conn, reader: io.MultiReader(bytes.NewReader(record), conn)}
+	if n < 5 || n < need || record[0] != 0x16 {
+		return "", wrapped
+	}
+
+	// 借助TLS握手解析ClientHello
+	var serverName string
+	tls.Server(&helloConn{Conn: conn, reader: bytes.NewReader(record[:need])}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errServerNameFound
+		},
+	}).Handshake()
+	return serverName, wrapped
+}
+
+// helloConn 仅供解析ClientHello的连接，读取预读数据并丢弃写入
+type helloConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+// Read 读取预读数据
+func (hc *helloConn) Read(b []byte) (int, error) { return hc.reader.Read(b) }
+
+// Write 丢弃写入，避免向客户端发送告警
+func (hc *helloConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// proxiedConn 携带入站PROXY头部中真实客户端地址的连接
+type proxiedConn struct {
+	readerConn
+	remoteAddr net.Addr // 真实客户端地址
+}
+
+// RemoteAddr 返回真实客户端地址
+func (pc *proxiedConn) RemoteAddr() net.Addr { return pc.remoteAddr }
+
+// trustedProxy 判断来源是否为可信代理
+func (c *Common) trustedProxy(addr net.Addr) bool {
+	if len(c.trustedProxies) == 0 {
+		return false
+	}
+	addrPort, err := proxyAddrPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, prefix := range c.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptProxyHeader 解析可信来源的入站PROXY v1/v2 头部，非可信来源原样返回
+func (c *Common) acceptProxyHeader(conn net.Conn) (net.Conn, error) {
+	if !c.trustedProxy(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(conn)
+	signature, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("acceptProxyHeader: peek failed: %w", err)
+	}
+
+	var addr net.Addr
+	switch {
+	case bytes.Equal(signature, proxyV2Signature):
+		addr, err = readProxyV2Header(reader)
+	case bytes.HasPrefix(signature, []byte("PROXY ")):
+		addr, err = readProxyV1Header(reader)
+	default:
+		return nil, fmt.Errorf("acceptProxyHeader: missing PROXY header from %v", conn.RemoteAddr())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acceptProxyHeader: %w", err)
+	}
+
+	// 本地命令或未知协议保留原地址
+	if addr == nil {
+		addr = conn.RemoteAddr()
+	}
+	c.logger.Debug("PROXY header accepted: %v -> %v", conn.RemoteAddr(), addr)
+	return &proxiedConn{readerConn: readerConn{Conn: conn, reader: reader}, remoteAddr: addr}, nil
+}
+
+// readProxyV1Header 读取PROXY v1 头部
+func readProxyV1Header(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("readProxyV1Header: read failed: %w", err)
+		}
+		if line = append(line, b); len(line) > proxyV1MaxSize {
+			return nil, fmt.Errorf("readProxyV1Header: header too long")
+		}
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("readProxyV1Header: malformed header")
+	}
+	ip, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("readProxyV1Header: invalid source address: %w", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("readProxyV1Header: invalid source port: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port))), nil
+}
+
+// readProxyV2Header 读取PROXY v2 头部
+func readProxyV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("readProxyV2Header: read header failed: %w", err)
+	}
+	command, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, fmt.Errorf("readProxyV2Header: read body failed: %w", err)
+	}
+
+	switch command {
+	case proxyV2Local:
+		return nil, nil
+	case proxyV2Command:
+	default:
+		return nil, fmt.Errorf("readProxyV2Header: unsupported command: %#x", command)
+	}
+
+	// 仅解析IPv4与IPv6地址，其余地址族保留原地址
+	var ip netip.Addr
+	var port uint16
+	switch family >> 4 {
+	case 0x1:
+		if len(body) < 12 {
+			return nil, fmt.Errorf("readProxyV2Header: short IPv4 address block")
+		}
+		ip, port = netip.AddrFrom4([4]byte(body[0:4])), binary.BigEndian.Uint16(body[8:10])
+	case 0x2:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("readProxyV2Header: short IPv6 address block")
+		}
+		ip, port = netip.AddrFrom16([16]byte(body[0:16])), binary.BigEndian.Uint16(body[32:34])
+	default:
+		return nil, nil
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), port)), nil
+}
@@ -0,0 +1,288 @@
+// 内部包，实现实例流量配额
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// 流量配额重置周期
+const (
+	quotaCycleNone    = ""        // 不重置
+	quotaCycleDaily   = "daily"   // 每日重置
+	quotaCycleMonthly = "monthly" // 每月重置
+)
+
+// 流量配额超额动作
+const (
+	quotaActionEvent    = "event"    // 仅发送事件
+	quotaActionStop     = "stop"     // 停止实例
+	quotaActionThrottle = "throttle" // 限速运行
+)
+
+// quotaCheckInterval 配额周期检查间隔
+const quotaCheckInterval = time.Minute
+
+// Quota 实例流量配额
+type Quota struct {
+	Limit    uint64    `json:"limit"`    // 流量上限字节数
+	Cycle    string    `json:"cycle"`    // 重置周期
+	Day      int       `json:"day"`      // 每月重置日
+	Hour     int       `json:"hour"`     // 重置时刻
+	Action   string    `json:"action"`   // 超额动作
+	Throttle int       `json:"throttle"` // 超额限速(Mbps)
+	Used     uint64    `json:"used"`     // 周期内已用字节数
+	Exceeded bool      `json:"exceeded"` // 是否已超额
+	ResetAt  time.Time `json:"reset_at"` // 下次重置时间
+	Last     uint64    `json:"-"`        // 上次累计流量
+	Halted   bool      `json:"-"`        // 是否因超额被停止
+}
+
+// validate 校验配额设置，上限为0表示移除配额
+func (q *Quota) validate() error {
+	if q.Limit == 0 {
+		return nil
+	}
+	switch q.Cycle {
+	case quotaCycleNone, quotaCycleDaily, quotaCycleMonthly:
+	default:
+		return fmt.Errorf("invalid quota cycle: %s", q.Cycle)
+	}
+	switch q.Action {
+	case "", quotaActionEvent, quotaActionStop:
+	case quotaActionThrottle:
+		if q.Throttle <= 0 {
+			return fmt.Errorf("quota throttle must be positive")
+		}
+	default:
+		return fmt.Errorf("invalid quota action: %s", q.Action)
+	}
+	if q.Day < 0 || q.Day > 28 || (q.Day == 0 && q.Cycle == quotaCycleMonthly) {
+		return fmt.Errorf("quota day must be between 1 and 28")
+	}
+	if q.Hour < 0 || q.Hour > 23 {
+		return fmt.Errorf("quota hour must be between 0 and 23")
+	}
+	return nil
+}
+
+// nextReset 计算下次重置时间
+func (q *Quota) nextReset(now time.Time) time.Time {
+	switch q.Cycle {
+	case quotaCycleDaily:
+		next := time.Date(now.Year(), now.Month(), now.Day(), q.Hour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	case quotaCycleMonthly:
+		next := time.Date(now.Year(), now.Month(), max(q.Day, 1), q.Hour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 1, 0)
+		}
+		return next
+	default:
+		return time.Time{}
+	}
+}
+
+// throttled 判断是否处于超额限速状态
+func (q *Quota) throttled() bool {
+	return q != nil && q.Exceeded && q.Action == quotaActionThrottle
+}
+
+// launchURL 返回实际运行的URL，超额限速时将rate参数降至限速值
+func (q *Quota) launchURL(rawURL string) string {
+	if !q.throttled() {
+		return rawURL
+	}
+	parsedURL, err := ParseURL(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsedURL.Query()
+	rate := q.Throttle
+	if existing, err := strconv.Atoi(query.Get("rate")); err == nil && existing > 0 {
+		rate = min(existing, rate)
+	}
+	query.Set("rate", strconv.Itoa(rate))
+	parsedURL.RawQuery = query.Encode()
+	return parsedURL.String()
+}
+
+// instanceTraffic 获取实例累计流量
+func instanceTraffic(instance *Instance) uint64 {
+	return instance.TCPRX + instance.TCPTX + instance.UDPRX + instance.UDPTX
+}
+
+// updateQuota 更新实例配额设置，保留周期内用量
+func (m *Master) updateQuota(instance *Instance, quota *Quota) {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+
+	// 上限为0时移除配额
+	if quota.Limit == 0 {
+		m.liftQuota(instance)
+		instance.Quota = nil
+		return
+	}
+
+	if quota.Action == "" {
+		quota.Action = quotaActionEvent
+	}
+	old := instance.Quota
+	if old != nil {
+		quota.Used, quota.Last, quota.Exceeded, quota.Halted = old.Used, old.Last, old.Exceeded, old.Halted
+	} else {
+		quota.Used, quota.Last, quota.Exceeded = 0, instanceTraffic(instance), false
+	}
+	quota.ResetAt = quota.nextReset(time.Now())
+
+	// 超额动作变化或上限提高时解除原有限制
+	if old != nil && old.Exceeded && (old.Action != quota.Action || old.Throttle != quota.Throttle || quota.Used < quota.Limit) {
+		m.liftQuota(instance)
+		quota.Exceeded, quota.Halted = false, false
+	}
+	instance.Quota = quota
+	m.accrueQuota(instance)
+}
+
+// checkQuota 累计配额用量并在超额时执行动作
+func (m *Master) checkQuota(instance *Instance) {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	m.accrueQuota(instance)
+}
+
+// accrueQuota 累计配额用量并在超额时执行动作，调用方需持有quotaMu
+func (m *Master) accrueQuota(instance *Instance) {
+	quota := instance.Quota
+	if quota == nil {
+		return
+	}
+
+	// 累计增量，流量统计被重置时从零计算
+	total := instanceTraffic(instance)
+	if total >= quota.Last {
+		quota.Used += total - quota.Last
+	} else {
+		quota.Used += total
+	}
+	quota.Last = total
+
+	if quota.Exceeded || quota.Used < quota.Limit {
+		return
+	}
+
+	quota.Exceeded = true
+	m.logger.Warn("Quota exceeded: %v/%v %v [%v]", quota.Used, quota.Limit, quota.Action, instance.ID)
+	m.sendSSEEvent("quota", instance)
+
+	switch quota.Action {
+	case quotaActionStop:
+		// 仅记录由配额停止的实例，重置后只恢复这些实例
+		if instance.Status != "stopped" {
+			quota.Halted = true
+			go m.stopInstance(instance)
+		}
+	case quotaActionThrottle:
+		go m.reapplyQuota(instance)
+	}
+	go m.saveState()
+}
+
+// liftQuota 解除超额限制，调用方需持有quotaMu
+func (m *Master) liftQuota(instance *Instance) {
+	quota := instance.Quota
+	if quota == nil || !quota.Exceeded {
+		return
+	}
+
+	action, halted := quota.Action, quota.Halted
+	quota.Exceeded, quota.Halted = false, false
+	m.logger.Info("Quota restored: %v/%v [%v]", quota.Used, quota.Limit, instance.ID)
+
+	switch action {
+	case quotaActionStop:
+		if halted && instance.Status == "stopped" && !instance.deleted {
+			go m.startInstance(instance)
+		}
+	case quotaActionThrottle:
+		go m.reapplyQuota(instance)
+	}
+}
+
+// clearHalted 清除配额停止标记，用于手动停止实例
+func (m *Master) clearHalted(instance *Instance) {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	if instance.Quota != nil {
+		instance.Quota.Halted = false
+	}
+}
+
+// quotaBlocked 检查实例是否因超额停止而不允许启动
+func (m *Master) quotaBlocked(instance *Instance) error {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	if quota := instance.Quota; quota != nil && quota.Exceeded && quota.Action == quotaActionStop {
+		return fmt.Errorf("quota exceeded: %v/%v", quota.Used, quota.Limit)
+	}
+	return nil
+}
+
+// quotaURL 返回按当前配额状态实际运行的URL
+func (m *Master) quotaURL(instance *Instance, rawURL string) string {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	return instance.Quota.launchURL(rawURL)
+}
+
+// reapplyQuota 按当前配额状态热重载运行中的实例，失败时重启
+func (m *Master) reapplyQuota(instance *Instance) {
+	if instance.Status != "running" {
+		return
+	}
+	if _, err := m.reloadInstance(instance, ""); err == nil {
+		return
+	}
+	m.restartInstance(instance)
+}
+
+// resetQuota 清零配额用量
+func (m *Master) resetQuota(instance *Instance) {
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	quota := instance.Quota
+	if quota == nil {
+		return
+	}
+	quota.Used, quota.Last = 0, instanceTraffic(instance)
+	m.liftQuota(instance)
+}
+
+// performQuotaReset 按周期重置到期的实例配额
+func (m *Master) performQuotaReset() {
+	now := time.Now()
+	m.instances.Range(func(key, value any) bool {
+		instance := value.(*Instance)
+		m.quotaMu.Lock()
+		quota := instance.Quota
+		if key.(string) == apiKeyID || quota == nil || quota.ResetAt.IsZero() || now.Before(quota.ResetAt) {
+			m.quotaMu.Unlock()
+			return true
+		}
+
+		quota.Used, quota.Last = 0, instanceTraffic(instance)
+		m.liftQuota(instance)
+		quota.ResetAt = quota.nextReset(now)
+		m.quotaMu.Unlock()
+
+		m.instances.Store(instance.ID, instance)
+		m.logger.Info("Quota cycle reset: next %v [%v]", quota.ResetAt.Format(time.RFC3339), instance.ID)
+		m.sendSSEEvent("quota", instance)
+		go m.saveState()
+		return true
+	})
+}
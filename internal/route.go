@@ -0,0 +1,139 @@
+// 内部包，实现单隧道多目标映射
+package internal
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/NodePassProject/conn"
+)
+
+// 目标映射常量
+const maxRouteNameLen = 32 // 映射名称最大长度
+
+// routeMapping 命名目标映射，与主目标组共享连接池和控制连接
+type routeMapping struct {
+	name           string                      // 映射名称
+	targets        atomic.Pointer[targetGroup] // 目标地址组
+	targetListener *net.TCPListener            // 目标监听器
+	targetUDPConn  *conn.StatConn              // 目标UDP连接
+}
+
+// validRouteName 判断映射名称是否合法
+func validRouteName(name string) bool {
+	if name == "" || len(name) > maxRouteNameLen {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// getRouteMappings 获取命名目标映射，每个map参数格式为name=addr[,addr...]
+func (c *Common) getRouteMappings() error {
+	entries := c.parsedURL.Query()["map"]
+	routes := make([]*routeMapping, 0, len(entries))
+	for _, entry := range entries {
+		name, addrs, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validRouteName(name) {
+			return fmt.Errorf("getRouteMappings: invalid mapping: %v", entry)
+		}
+		for _, route := range routes {
+			if route.name == name {
+				return fmt.Errorf("getRouteMappings: duplicate mapping: %v", name)
+			}
+		}
+
+		group, err := c.parseTargetAddrs(addrs)
+		if err != nil {
+			return fmt.Errorf("getRouteMappings: mapping %v: %w", name, err)
+		}
+		route := &routeMapping{name: name}
+		route.targets.Store(group)
+		routes = append(routes, route)
+	}
+	c.routes = routes
+	return nil
+}
+
+// routeTargets 获取映射对应的目标地址组，空名称对应主目标组
+func (c *Common) routeTargets(name string) *targetGroup {
+	if name == "" {
+		return c.targets.Load()
+	}
+	for _, route := range c.routes {
+		if route.name == name {
+			return route.targets.Load()
+		}
+	}
+	return nil
+}
+
+// logRoutes 输出命名目标映射与虚拟主机路由
+func (c *Common) logRoutes() {
+	for _, route := range c.routes {
+		c.logger.Info("Route mapping: %v -> %v", route.name, route.targets.Load())
+	}
+	if vhosts := c.vhosts.Load(); vhosts != nil {
+		for _, vhost := range *vhosts {
+			c.logger.Info("Virtual host: %v -> %v", vhost.host, vhost.route)
+		}
+	}
+}
+
+// initRouteListeners 初始化命名映射的目标监听器
+func (c *Common) initRouteListeners() error {
+	for _, route := range c.routes {
+		group := route.targets.Load()
+
+		// 初始化映射TCP监听器
+		if c.disableTCP != "1" {
+			targetListener, err := net.ListenTCP("tcp", group.tcpAddrs[0])
+			if err != nil {
+				return fmt.Errorf("initRouteListeners: mapping %v listenTCP failed: %w", route.name, err)
+			}
+			route.targetListener = targetListener
+		}
+
+		// 初始化映射UDP监听器
+		if c.ingressUDP() {
+			targetUDPConn, err := net.ListenUDP("udp", group.udpAddrs[0])
+			if err != nil {
+				return fmt.Errorf("initRouteListeners: mapping %v listenUDP failed: %w", route.name, err)
+			}
+			route.targetUDPConn = &conn.StatConn{Conn: targetUDPConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
+		}
+
+		// 初始化映射端口范围
+		udpAddr := group.udpAddrs[0]
+		if !c.ingressUDP() {
+			udpAddr = nil
+		}
+		if err := c.initPortRange(group.tcpAddrs[0], udpAddr, group.span, route.name); err != nil {
+			return fmt.Errorf("initRouteListeners: mapping %v: %w", route.name, err)
+		}
+	}
+	return nil
+}
+
+// closeRouteListeners 关闭命名映射的目标监听器
+func (c *Common) closeRouteListeners() {
+	for _, route := range c.routes {
+		if route.targetUDPConn != nil {
+			route.targetUDPConn.Close()
+			c.logger.Debug("Target connection closed: %v", route.targetUDPConn.LocalAddr())
+			route.targetUDPConn = nil
+		}
+		if route.targetListener != nil {
+			route.targetListener.Close()
+			c.logger.Debug("Target listener closed: %v", route.targetListener.Addr())
+			route.targetListener = nil
+		}
+	}
+}
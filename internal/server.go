@@ -12,12 +12,10 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/NodePassProject/logs"
 	"github.com/NodePassProject/nph2"
 	"github.com/NodePassProject/npws"
 	"github.com/NodePassProject/pool"
@@ -28,7 +26,7 @@ import (
 type Server struct{ Common }
 
 // NewServer 创建新的服务端实例
-func NewServer(parsedURL *url.URL, tlsCode string, tlsConfig *tls.Config, logger *logs.Logger) (*Server, error) {
+func NewServer(parsedURL *url.URL, tlsCode string, tlsConfig *tls.Config, logger Logger) (*Server, error) {
 	server := &Server{
 		Common: Common{
 			parsedURL:  parsedURL,
@@ -61,12 +59,17 @@ func NewServer(parsedURL *url.URL, tlsCode string, tlsConfig *tls.Config, logger
 // Run 管理服务端生命周期
 func (s *Server) Run() {
 	logInfo := func(prefix string) {
-		s.logger.Info("%v: server://%v@%v/%v?dns=%v&max=%v&mode=%v&type=%v&dial=%v&read=%v&rate=%v&slot=%v&proxy=%v&block=%v&notcp=%v&noudp=%v",
+		s.configMu.RLock()
+		defer s.configMu.RUnlock()
+		s.logger.Info("%v: server://%v@%v/%v?dns=%v&max=%v&mode=%v&type=%v&dial=%v&read=%v&rate=%v&slot=%v&proxy=%v&block=%v&notcp=%v&noudp=%v&lb=%v",
 			prefix, s.tunnelKey, s.tunnelTCPAddr, s.getTargetAddrsString(), s.dnsCacheTTL, s.maxPoolCapacity,
 			s.runMode, s.poolType, s.dialerIP, s.readTimeout, s.rateLimit/125000, s.slotLimit,
-			s.proxyProtocol, s.blockProtocol, s.disableTCP, s.disableUDP)
+			s.proxyProtocol, s.blockProtocol, s.disableTCP, s.disableUDP, s.lbStrategy)
 	}
 	logInfo("Server started")
+	s.logRoutes()
+	s.reportEvent(ipcEventStart)
+	go s.ipcCommandLoop()
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
@@ -76,6 +79,7 @@ func (s *Server) Run() {
 			// 启动服务端
 			if err := s.start(); err != nil && err != io.EOF {
 				s.logger.Error("Server error: %v", err)
+				s.reportError(err)
 				// 重启服务端
 				s.stop()
 				select {
@@ -84,6 +88,7 @@ func (s *Server) Run() {
 				case <-time.After(serviceCooldown):
 				}
 				logInfo("Server restart")
+				s.reportEvent(ipcEventRestart)
 			}
 		}
 	}()
@@ -91,6 +96,7 @@ func (s *Server) Run() {
 	// 监听系统信号以优雅关闭
 	<-ctx.Done()
 	stop()
+	s.reportEvent(ipcEventShutdown)
 
 	// 执行关闭过程
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
@@ -109,7 +115,7 @@ func (s *Server) start() error {
 
 	// 初始化隧道监听器
 	if err := s.initTunnelListener(); err != nil {
-		return fmt.Errorf("start: initTunnelListener failed: %w", err)
+		return withCode(errCodeListen, fmt.Errorf("start: initTunnelListener failed: %w", err))
 	}
 
 	// 关闭UDP监听器
@@ -121,7 +127,7 @@ func (s *Server) start() error {
 	switch s.runMode {
 	case "1": // 反向模式
 		if err := s.initTargetListener(); err != nil {
-			return fmt.Errorf("start: initTargetListener failed: %w", err)
+			return withCode(errCodeListen, fmt.Errorf("start: initTargetListener failed: %w", err))
 		}
 		s.dataFlow = "-"
 	case "2": // 正向模式
@@ -140,18 +146,18 @@ func (s *Server) start() error {
 	s.logger.Info("Pending tunnel handshake...")
 	s.handshakeStart = time.Now()
 	if err := s.tunnelHandshake(); err != nil {
-		return fmt.Errorf("start: tunnelHandshake failed: %w", err)
+		return withCode(errCodeHandshake, fmt.Errorf("start: tunnelHandshake failed: %w", err))
 	}
 
 	// 初始化连接池
 	if err := s.initTunnelPool(); err != nil {
-		return fmt.Errorf("start: initTunnelPool failed: %w", err)
+		return withCode(errCodePool, fmt.Errorf("start: initTunnelPool failed: %w", err))
 	}
 
 	// 设置控制连接
 	s.logger.Info("Getting tunnel pool ready...")
 	if err := s.setControlConn(); err != nil {
-		return fmt.Errorf("start: setControlConn failed: %w", err)
+		return withCode(errCodePool, fmt.Errorf("start: setControlConn failed: %w", err))
 	}
 
 	// 判断数据流向
@@ -161,7 +167,7 @@ func (s *Server) start() error {
 
 	// 启动共用控制
 	if err := s.commonControl(); err != nil {
-		return fmt.Errorf("start: commonControl failed: %w", err)
+		return withCode(errCodeControl, fmt.Errorf("start: commonControl failed: %w", err))
 	}
 	return nil
 }
@@ -235,13 +241,36 @@ func (s *Server) tunnelHandshake() error {
 			return
 		}
 
+		// 未携带令牌时签发挑战
+		if r.Header.Get("Authorization") == "" {
+			challenge, err := s.issueChallenge()
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set(authChallengeHeader, challenge)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
 		// 验证令牌
-		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") || !s.verifyAuthToken(strings.TrimPrefix(auth, "Bearer ")) {
+		if err := s.verifyAuthToken(r); err != nil {
+			s.rejectHandshake(r.RemoteAddr, err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
+		// 建立控制信道会话密钥
+		serverNonce, err := newControlNonce()
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := s.initControlCipher(r.Header.Get(controlNonceHeader), serverNonce); err != nil {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+
 		// 记录客户端地址
 		clientIP = r.RemoteAddr
 		if host, _, err := net.SplitHostPort(clientIP); err == nil {
@@ -251,10 +280,11 @@ func (s *Server) tunnelHandshake() error {
 		// 发送配置
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{
-			"flow": s.dataFlow,
-			"max":  s.maxPoolCapacity,
-			"tls":  s.tlsCode,
-			"type": s.poolType,
+			"flow":  s.dataFlow,
+			"max":   s.maxPoolCapacity,
+			"tls":   s.tlsCode,
+			"type":  s.poolType,
+			"nonce": serverNonce,
 		})
 
 		s.logger.Info("Sending tunnel config: FLOW=%v|MAX=%v|TLS=%v|TYPE=%v",
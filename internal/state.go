@@ -0,0 +1,328 @@
+// 内部包，实现主控实例状态存储
+package internal
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 状态存储常量
+const (
+	stateVersion     = 1              // 当前状态文件版本
+	legacyStatePath  = "gob"          // 旧版状态文件路径
+	legacyStateName  = "nodepass.gob" // 旧版状态文件名
+	maxStateBodySize = 16 << 20       // 状态导入最大字节数
+)
+
+// stateMigrations 状态文件迁移函数，键为迁移前版本
+var stateMigrations = map[int]func(raw map[string]any) error{}
+
+// StateFile 版本化状态文件
+type StateFile struct {
+	Version   int                       `json:"version"`   // 状态文件版本
+	Saved     time.Time                 `json:"saved"`     // 保存时间
+	Instances map[string]*StateInstance `json:"instances"` // 实例映射表
+}
+
+// StateInstance 实例持久化字段，与API结构解耦
+type StateInstance struct {
+	ID       string      `json:"id"`              // 实例ID
+	Alias    string      `json:"alias"`           // 实例别名
+	Type     string      `json:"type"`            // 实例类型
+	URL      string      `json:"url"`             // 实例URL
+	Config   string      `json:"config"`          // 实例配置
+	Restart  bool        `json:"restart"`         // 是否自启动
+	Managed  bool        `json:"managed"`         // 是否由配置文件管理
+	Meta     Meta        `json:"meta"`            // 元数据信息
+	TCPRX    uint64      `json:"tcprx"`           // TCP接收字节数
+	TCPTX    uint64      `json:"tcptx"`           // TCP发送字节数
+	UDPRX    uint64      `json:"udprx"`           // UDP接收字节数
+	UDPTX    uint64      `json:"udptx"`           // UDP发送字节数
+	Restarts uint64      `json:"restarts"`        // 重启次数
+	Rejects  uint64      `json:"rejects"`         // 拒绝连接数
+	Quota    *StateQuota `json:"quota,omitempty"` // 流量配额
+	Keys     []*StateKey `json:"keys,omitempty"`  // 命名API Key
+}
+
+// StateQuota 流量配额持久化字段
+type StateQuota struct {
+	Quota
+	Last   uint64 `json:"last"`             // 上次累计流量
+	Halted bool   `json:"halted,omitempty"` // 是否因超额被停止
+}
+
+// StateKey 命名API Key持久化字段
+type StateKey struct {
+	APIKey
+	Hash string `json:"hash"` // Key哈希
+}
+
+// toState 转换为持久化字段
+func (instance *Instance) toState() *StateInstance {
+	state := &StateInstance{
+		ID:       instance.ID,
+		Alias:    instance.Alias,
+		Type:     instance.Type,
+		URL:      instance.URL,
+		Config:   instance.Config,
+		Restart:  instance.Restart,
+		Managed:  instance.Managed,
+		Meta:     instance.Meta,
+		TCPRX:    instance.TCPRX,
+		TCPTX:    instance.TCPTX,
+		UDPRX:    instance.UDPRX,
+		UDPTX:    instance.UDPTX,
+		Restarts: instance.Restarts,
+		Rejects:  instance.Rejects,
+	}
+	if instance.Quota != nil {
+		state.Quota = &StateQuota{Quota: *instance.Quota, Last: instance.Quota.Last, Halted: instance.Quota.Halted}
+	}
+	for _, key := range instance.Keys {
+		state.Keys = append(state.Keys, &StateKey{APIKey: *key, Hash: key.Hash})
+	}
+	return state
+}
+
+// toInstance 从持久化字段恢复实例
+func (state *StateInstance) toInstance() *Instance {
+	instance := &Instance{
+		ID:       state.ID,
+		Alias:    state.Alias,
+		Type:     state.Type,
+		URL:      state.URL,
+		Config:   state.Config,
+		Restart:  state.Restart,
+		Managed:  state.Managed,
+		Meta:     state.Meta,
+		TCPRX:    state.TCPRX,
+		TCPTX:    state.TCPTX,
+		UDPRX:    state.UDPRX,
+		UDPTX:    state.UDPTX,
+		Restarts: state.Restarts,
+		Rejects:  state.Rejects,
+	}
+	if state.Quota != nil {
+		quota := state.Quota.Quota
+		quota.Last, quota.Halted = state.Quota.Last, state.Quota.Halted
+		instance.Quota = &quota
+	}
+	if len(state.Keys) > 0 {
+		instance.Keys = make(map[string]*APIKey, len(state.Keys))
+		for _, stateKey := range state.Keys {
+			key := stateKey.APIKey
+			key.Hash = stateKey.Hash
+			instance.Keys[key.ID] = &key
+		}
+	}
+	return instance
+}
+
+// snapshotState 生成当前实例状态
+func (m *Master) snapshotState() *StateFile {
+	state := &StateFile{
+		Version:   stateVersion,
+		Saved:     time.Now(),
+		Instances: make(map[string]*StateInstance),
+	}
+	m.keysMu.RLock()
+	defer m.keysMu.RUnlock()
+	m.quotaMu.Lock()
+	defer m.quotaMu.Unlock()
+	m.instances.Range(func(key, value any) bool {
+		state.Instances[key.(string)] = value.(*Instance).toState()
+		return true
+	})
+	return state
+}
+
+// decodeState 解码状态文件并迁移到当前版本
+func decodeState(data []byte) (map[string]*Instance, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("decodeState: unmarshal failed: %w", err)
+	}
+
+	version, ok := raw["version"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("decodeState: missing version")
+	}
+	if int(version) > stateVersion {
+		return nil, fmt.Errorf("decodeState: unsupported version %v", version)
+	}
+
+	// 逐版本迁移
+	migrated := int(version) < stateVersion
+	for v := int(version); v < stateVersion; v++ {
+		migrate, ok := stateMigrations[v]
+		if !ok {
+			return nil, fmt.Errorf("decodeState: no migration from version %v", v)
+		}
+		if err := migrate(raw); err != nil {
+			return nil, fmt.Errorf("decodeState: migrate version %v failed: %w", v, err)
+		}
+		raw["version"] = v + 1
+	}
+	if migrated {
+		var err error
+		if data, err = json.Marshal(raw); err != nil {
+			return nil, fmt.Errorf("decodeState: marshal failed: %w", err)
+		}
+	}
+
+	var state StateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("decodeState: unmarshal failed: %w", err)
+	}
+	instances := make(map[string]*Instance, len(state.Instances))
+	for id, stateInstance := range state.Instances {
+		if stateInstance == nil {
+			continue
+		}
+		instances[id] = stateInstance.toInstance()
+	}
+	return instances, nil
+}
+
+// decodeLegacyState 解码旧版gob状态文件
+func decodeLegacyState(filePath string) (map[string]*Instance, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("decodeLegacyState: open file failed: %w", err)
+	}
+	defer file.Close()
+
+	var instances map[string]*Instance
+	if err := gob.NewDecoder(file).Decode(&instances); err != nil {
+		return nil, fmt.Errorf("decodeLegacyState: decode file failed: %w", err)
+	}
+	return instances, nil
+}
+
+// readState 读取状态文件，不存在时转换旧版gob文件
+func (m *Master) readState() (map[string]*Instance, error) {
+	data, err := os.ReadFile(m.statePath)
+	if err == nil {
+		return decodeState(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("readState: read file failed: %w", err)
+	}
+
+	// 转换旧版状态文件
+	legacyPath := filepath.Join(filepath.Dir(filepath.Dir(m.statePath)), legacyStatePath, legacyStateName)
+	if _, err := os.Stat(legacyPath); err != nil {
+		return nil, nil
+	}
+	instances, err := decodeLegacyState(legacyPath)
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Info("Legacy state converted: %v -> %v", legacyPath, m.statePath)
+	return instances, nil
+}
+
+// restoreState 恢复实例并处理自启动
+func (m *Master) restoreState(instances map[string]*Instance) {
+	for id, instance := range instances {
+		instance.stopped = make(chan struct{})
+
+		// 重置实例状态
+		if instance.ID != apiKeyID {
+			instance.Status = "stopped"
+		}
+
+		// 生成完整配置
+		if instance.Config == "" && instance.ID != apiKeyID {
+			instance.Config = m.generateConfigURL(instance)
+		}
+
+		// 初始化标签映射
+		if instance.Meta.Tags == nil {
+			instance.Meta.Tags = make(map[string]string)
+		}
+
+		m.instances.Store(id, instance)
+
+		// 处理自启动
+		if instance.Restart && instance.ID != apiKeyID {
+			m.logger.Info("Auto-starting instance: %v [%v]", instance.URL, instance.ID)
+			m.startInstance(instance)
+			time.Sleep(baseDuration)
+		}
+	}
+}
+
+// handleState 处理状态导出与导入请求
+func (m *Master) handleState(w http.ResponseWriter, r *http.Request) {
+	if requestKey(r).scoped() {
+		httpError(w, "Forbidden: scoped key", http.StatusForbidden)
+		return
+	}
+
+	switch op := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("%s/state/", m.prefix)); {
+	case op == "export" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", stateFileName))
+		writeJSON(w, http.StatusOK, m.snapshotState())
+
+	case op == "import" && r.Method == http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxStateBodySize))
+		if err != nil {
+			httpError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		instances, err := decodeState(data)
+		if err != nil {
+			httpError(w, fmt.Sprintf("Invalid state: %v", err), http.StatusBadRequest)
+			return
+		}
+		if apiKey, ok := instances[apiKeyID]; !ok || apiKey.URL == "" {
+			httpError(w, "Invalid state: API Key instance required", http.StatusBadRequest)
+			return
+		}
+		auditAction(r, "import")
+
+		// 停止并移除现有实例
+		m.instances.Range(func(key, value any) bool {
+			instance := value.(*Instance)
+			instance.deleted = true
+			if instance.Status != "stopped" {
+				m.stopInstance(instance)
+			}
+			m.instances.Delete(key)
+			m.sendSSEEvent("delete", instance)
+			return true
+		})
+
+		// 加载导入实例并恢复主控身份
+		m.restoreState(instances)
+		apiKey := instances[apiKeyID]
+		if apiKey.Config == "" {
+			apiKey.Config = generateMID()
+		}
+		m.mid, m.alias = apiKey.Config, apiKey.Alias
+		if err := m.saveState(); err != nil {
+			m.logger.Error("handleState: save state failed: %v", err)
+		}
+
+		// 按配置文件重新协调受管实例
+		if m.declarePath != "" {
+			m.reconcileConfig()
+		}
+
+		// API Key可能已变更，断开现有SSE连接
+		go m.shutdownSSEConnections()
+		m.logger.Info("State imported: %v instances", len(instances))
+		writeJSON(w, http.StatusOK, map[string]any{"version": stateVersion, "instances": len(instances)})
+
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
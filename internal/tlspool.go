@@ -0,0 +1,216 @@
+// 内部包，实现自行完成TLS握手的客户端连接池
+package internal
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tlsPoolIDTimeout     = 1 * time.Minute        // 连接ID读取超时
+	tlsPoolRetryInterval = 50 * time.Millisecond  // 按ID获取重试间隔
+	tlsPoolIntervalStep  = 100 * time.Millisecond // 创建间隔调整步长
+	tlsPoolLowThreshold  = 0.2                    // 低水位比例
+	tlsPoolHighThreshold = 0.8                    // 高水位比例
+)
+
+// tlsPool 客户端连接池，拨号函数直接返回已完成握手的TLS连接，无需经本地回环桥接
+type tlsPool struct {
+	conns    sync.Map                 // 存储连接的映射表
+	idChan   chan string              // 可用ID通道
+	dialer   func() (net.Conn, error) // 创建连接的函数
+	errCount atomic.Int32             // 错误计数
+	capacity atomic.Int32             // 当前容量
+	minCap   int                      // 最小容量
+	maxCap   int                      // 最大容量
+	interval atomic.Int64             // 连接创建间隔
+	minIvl   time.Duration            // 最小间隔
+	maxIvl   time.Duration            // 最大间隔
+	ctx      context.Context          // 上下文
+	cancel   context.CancelFunc       // 取消函数
+}
+
+// newTLSPool 创建自行握手的客户端连接池
+func newTLSPool(minCap, maxCap int, minIvl, maxIvl time.Duration, dialer func() (net.Conn, error)) *tlsPool {
+	minCap, maxCap = max(min(minCap, maxCap), 1), max(minCap, maxCap, 1)
+	minIvl, maxIvl = min(minIvl, maxIvl), max(minIvl, maxIvl)
+	p := &tlsPool{
+		idChan: make(chan string, maxCap),
+		dialer: dialer,
+		minCap: minCap,
+		maxCap: maxCap,
+		minIvl: minIvl,
+		maxIvl: maxIvl,
+	}
+	p.capacity.Store(int32(minCap))
+	p.interval.Store(int64(minIvl))
+	return p
+}
+
+// createConnection 拨号并接收服务端分配的连接ID
+func (p *tlsPool) createConnection() bool {
+	conn, err := p.dialer()
+	if err != nil {
+		return false
+	}
+
+	conn.SetReadDeadline(time.Now().Add(tlsPoolIDTimeout))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		conn.Close()
+		return false
+	}
+	conn.SetReadDeadline(time.Time{})
+	id := hex.EncodeToString(buf)
+
+	p.conns.Store(id, conn)
+	select {
+	case p.idChan <- id:
+		return true
+	default:
+		p.conns.Delete(id)
+		conn.Close()
+		return false
+	}
+}
+
+// ClientManager 客户端连接池管理器
+func (p *tlsPool) ClientManager() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	for p.ctx.Err() == nil {
+		p.adjustInterval()
+		need := int(p.capacity.Load()) - len(p.idChan)
+		var created atomic.Int32
+		if need > 0 {
+			var wg sync.WaitGroup
+			for range need {
+				wg.Go(func() {
+					if p.createConnection() {
+						created.Add(1)
+					}
+				})
+			}
+			wg.Wait()
+		}
+		p.adjustCapacity(int(created.Load()))
+
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(time.Duration(p.interval.Load())):
+		}
+	}
+}
+
+// OutgoingGet 根据ID获取可用池连接
+func (p *tlsPool) OutgoingGet(id string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	for {
+		if conn, ok := p.conns.LoadAndDelete(id); ok {
+			<-p.idChan
+			return conn.(net.Conn), nil
+		}
+		select {
+		case <-time.After(tlsPoolRetryInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("outgoingGet: pool connection not found")
+		}
+	}
+}
+
+// IncomingGet 获取可用池连接返回ID
+func (p *tlsPool) IncomingGet(timeout time.Duration) (string, net.Conn, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, timeout)
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", nil, fmt.Errorf("incomingGet: insufficient pool connections")
+		case id := <-p.idChan:
+			if conn, ok := p.conns.LoadAndDelete(id); ok {
+				return id, conn.(net.Conn), nil
+			}
+		}
+	}
+}
+
+// Flush 清空连接池中的所有连接
+func (p *tlsPool) Flush() {
+	p.conns.Range(func(key, value any) bool {
+		value.(net.Conn).Close()
+		p.conns.Delete(key)
+		return true
+	})
+	for {
+		select {
+		case <-p.idChan:
+		default:
+			return
+		}
+	}
+}
+
+// Close 关闭连接池并释放资源
+func (p *tlsPool) Close() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.Flush()
+}
+
+// Ready 检查连接池是否已初始化
+func (p *tlsPool) Ready() bool { return p.ctx != nil }
+
+// Active 获取当前活跃连接数
+func (p *tlsPool) Active() int { return len(p.idChan) }
+
+// Capacity 获取当前连接池容量
+func (p *tlsPool) Capacity() int { return int(p.capacity.Load()) }
+
+// Interval 获取当前连接创建间隔
+func (p *tlsPool) Interval() time.Duration { return time.Duration(p.interval.Load()) }
+
+// AddError 增加错误计数
+func (p *tlsPool) AddError() { p.errCount.Add(1) }
+
+// ErrorCount 获取错误计数
+func (p *tlsPool) ErrorCount() int { return int(p.errCount.Load()) }
+
+// ResetError 重置错误计数
+func (p *tlsPool) ResetError() { p.errCount.Store(0) }
+
+// adjustInterval 根据空闲连接数调整创建间隔
+func (p *tlsPool) adjustInterval() {
+	idle := float64(len(p.idChan))
+	capacity := float64(p.capacity.Load())
+	interval := time.Duration(p.interval.Load())
+	if idle < capacity*tlsPoolLowThreshold && interval > p.minIvl {
+		p.interval.Store(int64(max(interval-tlsPoolIntervalStep, p.minIvl)))
+	}
+	if idle > capacity*tlsPoolHighThreshold && interval < p.maxIvl {
+		p.interval.Store(int64(min(interval+tlsPoolIntervalStep, p.maxIvl)))
+	}
+}
+
+// adjustCapacity 根据创建成功率调整池容量
+func (p *tlsPool) adjustCapacity(created int) {
+	capacity := int(p.capacity.Load())
+	ratio := float64(created) / float64(capacity)
+	if ratio < tlsPoolLowThreshold && capacity > p.minCap {
+		p.capacity.Add(-1)
+	}
+	if ratio > tlsPoolHighThreshold && capacity < p.maxCap {
+		p.capacity.Add(1)
+	}
+}
@@ -0,0 +1,198 @@
+// 内部包，实现透明代理入口
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/NodePassProject/conn"
+)
+
+// getTransparentDst 获取透明代理连接的原始目标，拒绝直接访问监听端口的连接
+func (c *Common) getTransparentDst(targetConn net.Conn, listener net.Listener) (string, error) {
+	localAddr := targetConn.LocalAddr().(*net.TCPAddr)
+	if c.ingressMode == ingressTProxy {
+		// TPROXY保留原始目标为本地地址
+		if listenerAddr(localAddr, listener.Addr().(*net.TCPAddr)) {
+			return "", fmt.Errorf("getTransparentDst: direct connection from %v rejected", targetConn.RemoteAddr())
+		}
+		return localAddr.String(), nil
+	}
+
+	// REDIRECT改写目标后由连接跟踪记录原始目标
+	dst, err := getOriginalDst(targetConn)
+	if err != nil {
+		return "", fmt.Errorf("getTransparentDst: %w", err)
+	}
+	if dst == localAddr.String() {
+		return "", fmt.Errorf("getTransparentDst: direct connection from %v rejected", targetConn.RemoteAddr())
+	}
+	return dst, nil
+}
+
+// listenerAddr 判断本地地址是否为监听器自身地址，未指定监听IP时比较本机接口地址
+func listenerAddr(localAddr, listenAddr *net.TCPAddr) bool {
+	if localAddr.Port != listenAddr.Port {
+		return false
+	}
+	local, _ := netip.AddrFromSlice(localAddr.IP)
+	local = local.Unmap()
+	if !listenAddr.IP.IsUnspecified() {
+		listen, _ := netip.AddrFromSlice(listenAddr.IP)
+		return local == listen.Unmap()
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return true
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipNet.IP); ok && ip.Unmap() == local {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// transparentUDPLoop 透明代理UDP处理循环，会话按来源与原始目标区分
+func (c *Common) transparentUDPLoop(udpConn *net.UDPConn) {
+	statConn := &conn.StatConn{Conn: udpConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
+	oob := make([]byte, 128)
+	for c.ctx.Err() == nil {
+		buffer := c.getUDPBuffer()
+
+		// 读取数据报与原始目标
+		x, oobn, _, clientAddr, err := statConn.ReadMsgUDP(buffer, oob)
+		if err != nil {
+			c.putUDPBuffer(buffer)
+			if c.ctx.Err() != nil || err == net.ErrClosed {
+				return
+			}
+			c.logger.Error("transparentUDPLoop: readMsgUDP failed: %v", err)
+
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(contextCheckInterval):
+			}
+			continue
+		}
+
+		dstAddr, err := parseOrigDst(oob[:oobn])
+		if err != nil {
+			c.logger.Debug("transparentUDPLoop: %v", err)
+			c.putUDPBuffer(buffer)
+			continue
+		}
+
+		var remoteConn net.Conn
+		sessionKey := clientAddr.String() + ">" + dstAddr.String()
+
+		// 获取或创建UDP会话
+		if session, ok := c.targetUDPSession.Load(sessionKey); ok {
+			remoteConn = session.(net.Conn)
+		} else {
+			// 来源访问控制
+			releaseSource, err := c.admitSource(clientAddr)
+			if err != nil {
+				c.logger.Debug("transparentUDPLoop: %v", err)
+				c.putUDPBuffer(buffer)
+				continue
+			}
+
+			// 尝试获取UDP连接槽位
+			if !c.tryAcquireSlot(true) {
+				c.logger.Error("transparentUDPLoop: UDP slot limit reached: %v/%v", c.udpSlot, c.slotLimit)
+				atomic.AddUint64(&c.rejects, 1)
+				releaseSource()
+				c.putUDPBuffer(buffer)
+				continue
+			}
+
+			// 以原始目标为源地址回复客户端
+			replyUDPConn, err := listenTransparentUDP(dstAddr, false)
+			if err != nil {
+				c.logger.Error("transparentUDPLoop: %v", err)
+				releaseSource()
+				c.releaseSlot(true)
+				c.putUDPBuffer(buffer)
+				continue
+			}
+			replyConn := &conn.StatConn{Conn: replyUDPConn, RX: &c.udpRX, TX: &c.udpTX, Rate: c.rateLimiter.Load()}
+
+			// 获取池连接
+			id, poolConn, err := c.tunnelPool.IncomingGet(poolGetTimeout)
+			if err != nil {
+				c.logger.Warn("transparentUDPLoop: request timeout: %v", err)
+				replyConn.Close()
+				releaseSource()
+				c.releaseSlot(true)
+				c.putUDPBuffer(buffer)
+				continue
+			}
+			remoteConn = poolConn
+			c.targetUDPSession.Store(sessionKey, remoteConn)
+			c.logger.Debug("Tunnel connection: get %v <- pool active %v", id, c.tunnelPool.Active())
+
+			go func(remoteConn net.Conn, replyConn *conn.StatConn, clientAddr *net.UDPAddr, sessionKey, id string) {
+				defer func() {
+					// 清理UDP会话和释放槽位
+					c.targetUDPSession.Delete(sessionKey)
+					replyConn.Close()
+					c.releaseSlot(true)
+					releaseSource()
+					remoteConn.Close()
+					c.logger.Debug("Tunnel connection: closed %v", id)
+				}()
+
+				buffer := c.getUDPBuffer()
+				defer c.putUDPBuffer(buffer)
+				reader := &conn.TimeoutReader{Conn: remoteConn, Timeout: udpReadTimeout}
+
+				for c.ctx.Err() == nil {
+					x, err := reader.Read(buffer)
+					if err != nil {
+						if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+							c.logger.Debug("UDP session abort: %v", err)
+						} else if err != io.EOF {
+							c.logger.Error("transparentUDPLoop: read from tunnel failed: %v", err)
+						}
+						return
+					}
+					if _, err := replyConn.WriteToUDP(buffer[:x], clientAddr); err != nil {
+						c.logger.Error("transparentUDPLoop: writeToUDP failed: %v", err)
+						return
+					}
+				}
+			}(remoteConn, replyConn, clientAddr, sessionKey, id)
+
+			// 构建并发送启动信号
+			if c.ctx.Err() == nil && c.controlConn != nil {
+				signalData, _ := json.Marshal(Signal{
+					ActionType: "udp",
+					RemoteAddr: clientAddr.String(),
+					PoolConnID: id,
+					Target:     dstAddr.String(),
+				})
+				c.writeChan <- signalData
+			}
+			c.logger.Debug("UDP launch signal: cid %v -> %v", id, dstAddr)
+		}
+
+		// 将原始数据写入池连接
+		if _, err := remoteConn.Write(buffer[:x]); err != nil {
+			if err != io.EOF {
+				c.logger.Error("transparentUDPLoop: write to tunnel failed: %v", err)
+			}
+			c.targetUDPSession.Delete(sessionKey)
+			remoteConn.Close()
+		}
+		c.putUDPBuffer(buffer)
+	}
+}
@@ -0,0 +1,152 @@
+//go:build linux
+
+// 内部包，实现Linux透明代理套接字操作
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// Linux透明代理套接字选项
+const (
+	soOriginalDst       = 80 // SO_ORIGINAL_DST与IP6T_SO_ORIGINAL_DST
+	ipv6Transparent     = 75 // IPV6_TRANSPARENT
+	ipv6RecvOrigDstAddr = 74 // IPV6_RECVORIGDSTADDR
+	ipRecvOrigDstAddr   = 20 // IP_RECVORIGDSTADDR
+)
+
+// transparentControl 返回设置透明代理选项的套接字控制函数
+func transparentControl(ipv4, recvOrigDst bool) func(network, address string, rc syscall.RawConn) error {
+	return func(network, address string, rc syscall.RawConn) error {
+		var sockErr error
+		err := rc.Control(func(fd uintptr) {
+			level, transparent, recv := syscall.SOL_IPV6, ipv6Transparent, ipv6RecvOrigDstAddr
+			if ipv4 {
+				level, transparent, recv = syscall.SOL_IP, syscall.IP_TRANSPARENT, ipRecvOrigDstAddr
+			}
+			if sockErr = syscall.SetsockoptInt(int(fd), level, transparent, 1); sockErr != nil {
+				return
+			}
+			if recvOrigDst {
+				sockErr = syscall.SetsockoptInt(int(fd), level, recv, 1)
+				return
+			}
+
+			// 回复套接字可能与其他会话绑定同一原始目标
+			if strings.HasPrefix(network, "udp") {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// listenTransparentTCP 以IP_TRANSPARENT监听TCP，用于TPROXY
+func listenTransparentTCP(addr *net.TCPAddr) (*net.TCPListener, error) {
+	ipv4 := addr.IP == nil || addr.IP.To4() != nil
+	network := "tcp6"
+	if ipv4 {
+		network = "tcp4"
+	}
+	lc := net.ListenConfig{Control: transparentControl(ipv4, false)}
+	listener, err := lc.Listen(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("listenTransparentTCP: %w", err)
+	}
+	return listener.(*net.TCPListener), nil
+}
+
+// listenTransparentUDP 以IP_TRANSPARENT监听UDP，可绑定非本机地址用于回复
+func listenTransparentUDP(addr *net.UDPAddr, recvOrigDst bool) (*net.UDPConn, error) {
+	ipv4 := addr.IP == nil || addr.IP.To4() != nil
+	network := "udp6"
+	if ipv4 {
+		network = "udp4"
+	}
+	lc := net.ListenConfig{Control: transparentControl(ipv4, recvOrigDst)}
+	packetConn, err := lc.ListenPacket(context.Background(), network, addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("listenTransparentUDP: %w", err)
+	}
+	return packetConn.(*net.UDPConn), nil
+}
+
+// getOriginalDst 通过SO_ORIGINAL_DST读取REDIRECT前的原始目标
+func getOriginalDst(conn net.Conn) (string, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", fmt.Errorf("getOriginalDst: not a TCP connection")
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", fmt.Errorf("getOriginalDst: %w", err)
+	}
+
+	ipv4 := tcpConn.LocalAddr().(*net.TCPAddr).IP.To4() != nil
+	var dst *net.TCPAddr
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if ipv4 {
+			// sockaddr_in读入20字节的ipv6_mreq
+			mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+			if sockErr = err; err != nil {
+				return
+			}
+			dst = &net.TCPAddr{
+				IP:   net.IP(append([]byte(nil), mreq.Multiaddr[4:8]...)),
+				Port: int(binary.BigEndian.Uint16(mreq.Multiaddr[2:4])),
+			}
+			return
+		}
+
+		// sockaddr_in6读入32字节的ip6_mtuinfo
+		info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst)
+		if sockErr = err; err != nil {
+			return
+		}
+		port := make([]byte, 2)
+		binary.NativeEndian.PutUint16(port, info.Addr.Port)
+		dst = &net.TCPAddr{
+			IP:   net.IP(append([]byte(nil), info.Addr.Addr[:]...)),
+			Port: int(binary.BigEndian.Uint16(port)),
+		}
+	})
+	if err == nil {
+		err = sockErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("getOriginalDst: %w", err)
+	}
+	return dst.String(), nil
+}
+
+// parseOrigDst 从控制消息解析TPROXY数据报的原始目标
+func parseOrigDst(oob []byte) (*net.UDPAddr, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("parseOrigDst: %w", err)
+	}
+	for _, msg := range messages {
+		switch {
+		case msg.Header.Level == syscall.SOL_IP && msg.Header.Type == ipRecvOrigDstAddr && len(msg.Data) >= 8:
+			return &net.UDPAddr{
+				IP:   net.IP(append([]byte(nil), msg.Data[4:8]...)),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}, nil
+		case msg.Header.Level == syscall.SOL_IPV6 && msg.Header.Type == ipv6RecvOrigDstAddr && len(msg.Data) >= 24:
+			return &net.UDPAddr{
+				IP:   net.IP(append([]byte(nil), msg.Data[8:24]...)),
+				Port: int(binary.BigEndian.Uint16(msg.Data[2:4])),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("parseOrigDst: original destination not found")
+}
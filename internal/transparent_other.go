@@ -0,0 +1,32 @@
+//go:build !linux
+
+// 内部包，非Linux平台不支持透明代理
+package internal
+
+import (
+	"errors"
+	"net"
+)
+
+// errTransparentUnsupported 透明代理仅支持Linux
+var errTransparentUnsupported = errors.New("transparent proxy requires linux")
+
+// listenTransparentTCP 非Linux平台不支持透明监听
+func listenTransparentTCP(addr *net.TCPAddr) (*net.TCPListener, error) {
+	return nil, errTransparentUnsupported
+}
+
+// listenTransparentUDP 非Linux平台不支持透明监听
+func listenTransparentUDP(addr *net.UDPAddr, recvOrigDst bool) (*net.UDPConn, error) {
+	return nil, errTransparentUnsupported
+}
+
+// getOriginalDst 非Linux平台不支持读取原始目标
+func getOriginalDst(conn net.Conn) (string, error) {
+	return "", errTransparentUnsupported
+}
+
+// parseOrigDst 非Linux平台不支持读取原始目标
+func parseOrigDst(oob []byte) (*net.UDPAddr, error) {
+	return nil, errTransparentUnsupported
+}
@@ -0,0 +1,137 @@
+// 内部包，实现按主机名路由的虚拟主机
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"time"
+)
+
+// 虚拟主机常量
+const maxHostPeek = 8192 // HTTP请求头预读上限
+
+// virtualHost 主机名到命名映射的路由规则
+type virtualHost struct {
+	host  string // 主机名，*.前缀匹配子域名
+	route string // 命名映射名称
+}
+
+// getVirtualHosts 获取虚拟主机路由，格式为host=name[,host=name...]
+func (c *Common) getVirtualHosts() {
+	var vhosts []virtualHost
+	for entry := range strings.SplitSeq(c.parsedURL.Query().Get("vhost"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		host, route, ok := strings.Cut(entry, "=")
+		host = strings.ToLower(strings.TrimSpace(host))
+		route = strings.TrimSpace(route)
+		if !ok || !validHostPattern(host) || !validRouteName(route) {
+			c.logger.Warn("getVirtualHosts: invalid vhost entry ignored: %v", entry)
+			continue
+		}
+		vhosts = append(vhosts, virtualHost{host: host, route: route})
+	}
+	c.vhosts.Store(&vhosts)
+}
+
+// validHostPattern 校验主机名规则，通配符仅允许*.domain形式
+func validHostPattern(host string) bool {
+	name := strings.TrimPrefix(host, "*.")
+	return name != "" && !strings.Contains(name, "*")
+}
+
+// matchVirtualHost 按主机名匹配命名映射，精确匹配优先于通配符
+func matchVirtualHost(vhosts []virtualHost, host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, vhost := range vhosts {
+		if vhost.host == host {
+			return vhost.route, true
+		}
+	}
+	for _, vhost := range vhosts {
+		if suffix, ok := strings.CutPrefix(vhost.host, "*."); ok && strings.HasSuffix(host, "."+suffix) {
+			return vhost.route, true
+		}
+	}
+	return "", false
+}
+
+// routeVirtualHost 按HTTP Host头部或TLS SNI选择命名映射，未匹配时使用主目标组
+func (c *Common) routeVirtualHost(conn net.Conn) (string, net.Conn) {
+	vhosts := c.vhosts.Load()
+	if vhosts == nil || len(*vhosts) == 0 {
+		return "", conn
+	}
+
+	host, conn := c.peekHostName(conn)
+	if host == "" {
+		c.logger.Debug("routeVirtualHost: no host name from %v", conn.RemoteAddr())
+		return "", conn
+	}
+	route, ok := matchVirtualHost(*vhosts, host)
+	if !ok {
+		c.logger.Debug("routeVirtualHost: unmatched host %v from %v", host, conn.RemoteAddr())
+		return "", conn
+	}
+	c.logger.Debug("routeVirtualHost: %v -> %v", host, route)
+	return route, conn
+}
+
+// peekHostName 预读TLS SNI或HTTP Host头部，不消耗连接数据
+func (c *Common) peekHostName(conn net.Conn) (string, net.Conn) {
+	reader := bufio.NewReaderSize(conn, maxHostPeek)
+	wrapped := &readerConn{Conn: conn, reader: reader}
+
+	// 服务端先发言的协议不会发送数据，短暂等待后回退到默认目标
+	conn.SetReadDeadline(time.Now().Add(sniPeekTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	b, err := reader.Peek(1)
+	if err != nil {
+		return "", wrapped
+	}
+	deadline := time.Now().Add(handshakeTimeout)
+	conn.SetReadDeadline(deadline)
+
+	// TLS记录交由ClientHello解析，沿用首字节到达后的截止时间
+	if b[0] == 0x16 {
+		return c.peekServerName(wrapped, deadline)
+	}
+
+	// 预读完整HTTP请求头
+	for {
+		header, err := reader.Peek(reader.Buffered())
+		if end := bytes.Index(header, []byte("\r\n\r\n")); end >= 0 {
+			return parseHostHeader(header[:end]), wrapped
+		}
+		if err != nil || reader.Buffered() >= maxHostPeek {
+			return "", wrapped
+		}
+		if _, err := reader.Peek(reader.Buffered() + 1); err != nil {
+			return "", wrapped
+		}
+	}
+}
+
+// parseHostHeader 从HTTP请求头解析主机名
+func parseHostHeader(header []byte) string {
+	lines := strings.Split(string(header), "\r\n")
+	if len(lines) == 0 || !strings.HasSuffix(lines[0], " HTTP/1.1") && !strings.HasSuffix(lines[0], " HTTP/1.0") {
+		return ""
+	}
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(name), "Host") {
+			continue
+		}
+		host := strings.TrimSpace(value)
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		return strings.Trim(host, "[]")
+	}
+	return ""
+}